@@ -0,0 +1,57 @@
+package animax
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AudioTracks returns the audio streams of the video in file order. The position of a stream in the list is the index SelectAudioTrack takes.
+func (video Video) AudioTracks() []StreamInfo {
+	if video.Info == nil {
+		return []StreamInfo{}
+	}
+	return video.Info.StreamsOfType(audio)
+}
+
+/*
+	SelectAudioTrack picks the audio stream rendered with the video when it has several, by its position among the audio streams,
+	starting at 0. Audio filters, AddAudioTrack and the copied audio all use the selected stream.
+*/
+func (video *Video) SelectAudioTrack(index int) (modifiedVideo *Video) {
+	if index < 0 || (video.Info != nil && index >= len(video.AudioTracks())) {
+		Logger.Errorf("Video: %s | Audio track %d does not exist, the video has %d", video.FileName, index, len(video.AudioTracks()))
+		return &Video{}
+	}
+	selected := *video
+	selected.audioStream = fmt.Sprintf("0:a:%d", index)
+	return &selected
+}
+
+// SelectAudioLanguage picks the first audio stream tagged with language, e.g. "eng" or "jpn". See SelectAudioTrack.
+func (video *Video) SelectAudioLanguage(language string) (modifiedVideo *Video) {
+	for index, stream := range video.AudioTracks() {
+		if strings.EqualFold(stream.Language, language) {
+			return video.SelectAudioTrack(index)
+		}
+	}
+	if video.Info != nil && len(video.Info.Streams) > 0 {
+		Logger.Errorf("Video: %s | No audio track in %s", video.FileName, language)
+		return &Video{}
+	}
+
+	// without probe results, ffmpeg matches the language itself
+	selected := *video
+	selected.audioStream = fmt.Sprintf("0:a:m:language:%s", language)
+	return &selected
+}
+
+// audioSource returns the stream specifier of the audio rendered with file, "0:a" unless a track was selected.
+func audioSource(file File) string {
+	switch video := file.(type) {
+	case *Video:
+		if video.audioStream != "" {return video.audioStream}
+	case Video:
+		if video.audioStream != "" {return video.audioStream}
+	}
+	return "0:a"
+}
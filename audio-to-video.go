@@ -0,0 +1,183 @@
+package animax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+var VISUALIZERS = struct {
+	Waves       string
+	Spectrum    string
+	Vectorscope string
+}{
+	Waves:       "showwaves",
+	Spectrum:    "showspectrum",
+	Vectorscope: "avectorscope",
+}
+
+/*
+	ToVideoOptions configures Audio.ToVideo. Background is an image, shown for the whole audio, or a video, looped to the length
+	of the audio; it is scaled and cropped to fill the frame. Visualizer is drawn over the background, or over black without one.
+*/
+type ToVideoOptions struct {
+	OutputPath  string
+	Background  string
+	Visualizer  string  // one of VISUALIZERS
+	Color       string  // color of the waves, white by default
+	AspectRatio float32 // one of ASPECT_RATIOS, ASPECT_RATIOS.Videos by default
+	Resolution  int64   // length of the longest side in pixels, 1920 by default
+	FrameRate   int     // 30 by default
+	Encoding    string  // video encoder, VIDEO_ENCODINGS.Best by default
+}
+
+// frameSize returns the width and height of the output, both even as yuv420p requires.
+func (options ToVideoOptions) frameSize() (int64, int64) {
+	even := func(value float64) int64 { return int64(math.Round(value/2)) * 2 }
+	if options.AspectRatio >= 1 {
+		return even(float64(options.Resolution)), even(float64(options.Resolution) / float64(options.AspectRatio))
+	}
+	return even(float64(options.Resolution) * float64(options.AspectRatio)), even(float64(options.Resolution))
+}
+
+/*
+	ToVideo renders the audio as a video, e.g. to upload a podcast with the Facebook uploaders. Effects applied to the audio
+	are kept. The video is encoded as H.264 and AAC in yuv420p by default, with the index at the start of the file.
+
+		episode, _ := animax.LoadAudio("episode.mp3")
+		video, err := episode.ToVideo(animax.ToVideoOptions{
+			OutputPath: "episode.mp4", Background: "cover.jpg", Visualizer: animax.VISUALIZERS.Waves, AspectRatio: animax.ASPECT_RATIOS.Square,
+		})
+*/
+func (audio Audio) ToVideo(options ToVideoOptions) (Video, error) {
+	return audio.ToVideoContext(context.Background(), options)
+}
+
+// Same as ToVideo but stops as soon as ctx is cancelled. Render options such as WithProgress apply as for RenderContext.
+func (audio Audio) ToVideoContext(ctx context.Context, options ToVideoOptions, renderOptions ...RenderOption) (Video, error) {
+	settings := newRenderSettings(renderOptions)
+	plan, err := audio.videoPlan(options, settings)
+	if err != nil {
+		return Video{}, err
+	}
+
+	if err := startRender(ctx, plan, settings); err != nil {
+		return Video{}, err
+	}
+	return LoadVideo(options.OutputPath)
+}
+
+// Returns the ffmpeg invocation ToVideo would run, without running it.
+func (audio Audio) ToVideoPlan(options ToVideoOptions, renderOptions ...RenderOption) (RenderPlan, error) {
+	return audio.videoPlan(options, newRenderSettings(renderOptions))
+}
+
+func (audio Audio) videoPlan(options ToVideoOptions, settings renderSettings) (RenderPlan, error) {
+	if options.OutputPath == "" {
+		return RenderPlan{}, errors.New("output path is required")
+	}
+	if options.AspectRatio <= 0 {options.AspectRatio = ASPECT_RATIOS.Videos}
+	if options.Resolution <= 0 {options.Resolution = 1920}
+	if options.FrameRate <= 0 {options.FrameRate = 30}
+	if options.Encoding == "" {options.Encoding = VIDEO_ENCODINGS.Best}
+	if options.Color == "" {options.Color = "white"}
+	switch options.Visualizer {
+	case "", VISUALIZERS.Waves, VISUALIZERS.Spectrum, VISUALIZERS.Vectorscope:
+	default:
+		return RenderPlan{}, fmt.Errorf("unknown visualizer %q", options.Visualizer)
+	}
+
+	encode := DefaultEncodeOptions(options.Encoding)
+	if settings.encode != nil {
+		encode = *settings.encode
+		if encode.Codec == "" {encode.Codec = options.Encoding}
+	}
+	if encode.PixelFormat == "" {encode.PixelFormat = "yuv420p"}
+	if encode.AudioCodec == "" {encode.AudioCodec = "aac"}
+	if err := encode.Validate(options.OutputPath); err != nil {
+		Logger.Errorf("outputPath: %s | %s", options.OutputPath, err)
+		return RenderPlan{}, err
+	}
+
+	width, height := options.frameSize()
+	fill := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,setsar=1,fps=%d", width, height, width, height, options.FrameRate)
+
+	cmd := []string{"ffmpeg", "-i", audio.FilePath}
+	graph := []string{}
+	switch FileType(options.Background) {
+	case "":
+		if options.Background == "" {
+			graph = append(graph, fmt.Sprintf("color=c=black:s=%dx%d:r=%d[bg]", width, height, options.FrameRate))
+			break
+		}
+		// anything that is not a known video or audio file is read as an image
+		cmd = append(cmd, "-loop", "1", "-framerate", fmt.Sprint(options.FrameRate), "-i", options.Background)
+		graph = append(graph, fmt.Sprintf("[1:v]%s[bg]", fill))
+	case video:
+		cmd = append(cmd, "-stream_loop", "-1", "-i", options.Background)
+		graph = append(graph, fmt.Sprintf("[1:v]%s[bg]", fill))
+	default:
+		return RenderPlan{}, fmt.Errorf("background %s is not an image or a video", options.Background)
+	}
+
+	audioFilters := audio.filterChain()
+	if options.Visualizer == "" {
+		if len(audioFilters) == 0 {audioFilters = []string{"anull"}}
+		graph = append(graph, fmt.Sprintf("[0:a]%s[a]", strings.Join(audioFilters, ",")), "[bg]null[v]")
+	} else {
+		graph = append(graph, fmt.Sprintf("[0:a]%s[a][viz]", strings.Join(append(audioFilters, "asplit=2"), ",")))
+		graph = append(graph, options.visualizer(width, height), "[bg][waves]overlay=(W-w)/2:(H-h)/2:shortest=1,format=yuv420p[v]")
+	}
+
+	cmd = append(cmd, "-filter_complex", strings.Join(graph, ";"), "-map", "[v]", "-map", "[a]")
+	cmd = append(cmd, encode.args(video)...)
+	if options.Background != "" && FileType(options.Background) == "" && encode.Codec == VIDEO_ENCODINGS.Best {
+		cmd = append(cmd, "-tune", "stillimage")
+	}
+	cmd = append(cmd, "-r", fmt.Sprint(options.FrameRate), "-movflags", "+faststart", "-shortest", "-y")
+	if settings.progress != nil {
+		cmd = append(cmd, "-progress", "pipe:1", "-nostats")
+	}
+
+	plan := RenderPlan{
+		InputPath:  audio.FilePath,
+		OutputPath: options.OutputPath,
+		WorkingDir: settings.workingDir(),
+	}
+	output := fmt.Sprintf("%s/stage-0%s", plan.WorkingDir, filepath.Ext(options.OutputPath))
+	plan.Stages = []PlanStage{{
+		Args:     append(cmd, output),
+		Input:    audio.FilePath,
+		Output:   output,
+		duration: audio.outputDuration(),
+	}}
+	return plan, nil
+}
+
+// visualizer returns the chain drawing the [viz] audio as the [waves] video.
+func (options ToVideoOptions) visualizer(width int64, height int64) string {
+	switch options.Visualizer {
+	case VISUALIZERS.Spectrum:
+		return fmt.Sprintf("[viz]showspectrum=s=%dx%d:mode=combined:color=intensity:slide=scroll,fps=%d,format=rgba[waves]", width, height/3, options.FrameRate)
+	case VISUALIZERS.Vectorscope:
+		side := int64(math.Min(float64(width), float64(height))) / 2
+		return fmt.Sprintf("[viz]avectorscope=s=%dx%d:rate=%d:zoom=1.5:draw=line,format=rgba[waves]", side, side, options.FrameRate)
+	}
+	return fmt.Sprintf("[viz]showwaves=s=%dx%d:mode=cline:rate=%d:colors=%s,format=rgba[waves]", width, height/3, options.FrameRate, options.Color)
+}
+
+// outputDuration is the duration in seconds of the audio once every trim is applied.
+func (audio Audio) outputDuration() float64 {
+	duration := audio.Length().Seconds()
+	window, ok := composeTrims(audio.effects.args()["-ss"])
+	if !ok {
+		return duration
+	}
+	if duration <= 0 || window.end < duration {
+		duration = window.end
+	}
+	return math.Max(duration-window.start, 0)
+}
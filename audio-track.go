@@ -0,0 +1,229 @@
+package animax
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// audioTrackFlag marks the effects added by AddAudioTrack. It is not an ffmpeg flag, collapseStages turns it into an input and a mix.
+const audioTrackFlag = "-audio_track"
+
+/*
+	AudioTrackOptions configures how AddAudioTrack puts a track under a video.
+	Volumes of 0 leave the level unchanged. Fades are relative to the rendered output, so they follow the trims of the video.
+*/
+type AudioTrackOptions struct {
+	Replace        bool    // drop the audio of the video instead of mixing the track with it, a shorter track is padded with silence
+	Volume         float64 // volume of the added track
+	OriginalVolume float64 // volume of the audio of the video, ignored with Replace
+	Loop           bool    // repeat the track until the video ends
+	FadeIn         time.Duration
+	FadeOut        time.Duration
+
+	// Duck lowers the track while the audio of the video is loud, e.g. music under speech.
+	Duck          bool
+	DuckThreshold float64 // level of the audio of the video above which the track is lowered, 0.05 by default
+	DuckRatio     float64 // how much the track is lowered, 8 by default
+}
+
+// audioTrack is an extra input mixed into the audio of a video.
+type audioTrack struct {
+	audio   Audio
+	options AudioTrackOptions
+}
+
+/*
+	AddAudioTrack mixes audio into the video, or replaces the audio of the video with it. The track starts with the rendered
+	output and stops with it, so trims applied before or after it apply to the video only. Effects applied to audio, such as
+	TrimRange or ChangeVolume, are kept. Audio effects chained after AddAudioTrack apply to the mix.
+
+		music, _ := animax.LoadAudio("music.mp3")
+		clip := video.TrimRange(10*time.Second, 40*time.Second).AddAudioTrack(music, animax.AudioTrackOptions{
+			Volume: 0.3, Loop: true, FadeOut: 2 * time.Second, Duck: true,
+		})
+*/
+func (video *Video) AddAudioTrack(audio Audio, options AudioTrackOptions) (modifiedVideo *Video) {
+	modifiedVideo = video.withEffect(audioTrackFlag,
+		subArg{
+			Key: "audio_track",
+			Value: audio.FilePath,
+		})
+	modifiedVideo.effects.track = &audioTrack{audio: audio, options: options}
+	return modifiedVideo
+}
+
+/*
+	audioGraph builds the audio chains of a filter graph. Filters are queued on the current label until a track is mixed in,
+	then the mix becomes the current label.
+*/
+type audioGraph struct {
+	graph   []string
+	filters []string
+	label   string
+	labels  int
+	inputs  []string
+	count   int // number of extra inputs
+	options []string
+	tracks  bool
+}
+
+func newAudioGraph(source string) *audioGraph {
+	return &audioGraph{label: source}
+}
+
+func (g *audioGraph) nextLabel() string {
+	g.labels++
+	return fmt.Sprintf("a%d", g.labels)
+}
+
+// addInput adds path as the next extra input of the command, after its input options, and returns its index.
+func (g *audioGraph) addInput(path string, options ...string) int {
+	g.inputs = append(g.inputs, options...)
+	g.inputs = append(g.inputs, "-i", path)
+	g.count++
+	return g.count
+}
+
+// flush writes the queued filters as a chain and returns the label of its output.
+func (g *audioGraph) flush() string {
+	if len(g.filters) == 0 {
+		return g.label
+	}
+	label := g.nextLabel()
+	g.graph = append(g.graph, fmt.Sprintf("[%s]%s[%s]", g.label, strings.Join(g.filters, ","), label))
+	g.filters, g.label = nil, label
+	return label
+}
+
+/*
+	mix adds track as the next input. length is the duration of the rendered output in seconds, 0 when unknown. hasAudio tells
+	whether the video has audio to mix the track with. Only the video is seeked, so the track starts with the rendered output.
+*/
+func (g *audioGraph) mix(track *audioTrack, length float64, hasAudio bool) {
+	options := track.options
+	input := g.addInput(track.audio.FilePath)
+	g.tracks = true
+
+	filters := track.audio.filterChain()
+	if options.Loop {
+		filters = append(filters, "aloop=loop=-1:size=2147483647")
+	}
+	if options.Volume > 0 && options.Volume != 1 {
+		filters = append(filters, fmt.Sprintf("volume=%f", options.Volume))
+	}
+	if options.FadeIn > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=in:st=0:d=%f", options.FadeIn.Seconds()))
+	}
+	if options.FadeOut > 0 && length > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%f:d=%f", math.Max(length-options.FadeOut.Seconds(), 0), options.FadeOut.Seconds()))
+	}
+	replace := options.Replace || !hasAudio
+	if replace && !options.Loop {
+		if length > 0 {
+			// a track shorter than the video is padded with silence and a longer one is cut, so the video keeps its length
+			filters = append(filters, fmt.Sprintf("apad=whole_dur=%f", length), fmt.Sprintf("atrim=end=%f", length))
+		} else {
+			filters = append(filters, "apad")
+		}
+	}
+	if len(filters) == 0 {
+		filters = append(filters, "anull")
+	}
+	music := g.nextLabel()
+	g.graph = append(g.graph, fmt.Sprintf("[%d:a]%s[%s]", input, strings.Join(filters, ","), music))
+
+	if replace {
+		// looped and padded tracks never end, the video decides when the output stops
+		if (options.Loop || length <= 0) && !containsFlag(g.options, "-shortest") {
+			g.options = append(g.options, "-shortest")
+		}
+		g.filters, g.label = nil, music
+		return
+	}
+
+	if options.OriginalVolume > 0 && options.OriginalVolume != 1 {
+		g.filters = append(g.filters, fmt.Sprintf("volume=%f", options.OriginalVolume))
+	}
+	original := g.flush()
+	if options.Duck {
+		threshold, ratio := options.DuckThreshold, options.DuckRatio
+		if threshold <= 0 {threshold = 0.05}
+		if ratio <= 0 {ratio = 8}
+		main, sidechain, ducked := g.nextLabel(), g.nextLabel(), g.nextLabel()
+		g.graph = append(g.graph,
+			fmt.Sprintf("[%s]asplit=2[%s][%s]", original, main, sidechain),
+			fmt.Sprintf("[%s][%s]sidechaincompress=threshold=%f:ratio=%f:attack=20:release=300[%s]", music, sidechain, threshold, ratio, ducked),
+		)
+		original, music = main, ducked
+	}
+	mixed := g.nextLabel()
+	g.graph = append(g.graph, fmt.Sprintf("[%s][%s]amix=inputs=2:duration=first:dropout_transition=0:normalize=0[%s]", original, music, mixed))
+	g.label = mixed
+}
+
+// finish ends the audio chains on the [a] label. It returns false when there is nothing to filter.
+func (g *audioGraph) finish() ([]string, bool) {
+	if !g.tracks {
+		if len(g.filters) == 0 {
+			return nil, false
+		}
+		return []string{fmt.Sprintf("[%s]%s[a]", g.label, strings.Join(g.filters, ","))}, true
+	}
+	if len(g.filters) > 0 {
+		g.graph = append(g.graph, fmt.Sprintf("[%s]%s[a]", g.label, strings.Join(g.filters, ",")))
+		return g.graph, true
+	}
+	last := len(g.graph) - 1
+	g.graph[last] = strings.TrimSuffix(g.graph[last], "["+g.label+"]") + "[a]"
+	return g.graph, true
+}
+
+// filterChain returns the effects applied to audio as filters of a graph it is an input of. Trims become atrim filters at their place in the chain.
+func (audio Audio) filterChain() []string {
+	filters := []string{}
+	trims := []subArg{}
+	for _, effect := range audio.effects.list() {
+		if effect.flag == "-ss" {
+			trims = append(trims, effect.arg)
+			continue
+		}
+		if filterStream(effect.flag, &audio) != "a" {continue}
+		// consecutive trims compose into one
+		if trim, ok := composeTrims(trims); ok {
+			filters = append(filters, trim.filter("a"))
+		}
+		trims = nil
+		filters = append(filters, effect.arg.Value)
+	}
+	if trim, ok := composeTrims(trims); ok {
+		filters = append(filters, trim.filter("a"))
+	}
+	return filters
+}
+
+func containsFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAudio reports whether file has an audio stream. Files that were not probed are assumed to have one.
+func hasAudio(file File) bool {
+	if video, ok := file.(*Video); ok && video.Info != nil && len(video.Info.Streams) > 0 {
+		return video.Info.AudioStream() != nil
+	}
+	return true
+}
+
+// fileLength is the duration of file in seconds.
+func fileLength(file File) float64 {
+	if video, ok := file.(*Video); ok {
+		return video.Length().Seconds()
+	}
+	return float64(file.GetDuration())
+}
@@ -1,11 +1,13 @@
 package animax
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Audio struct {
@@ -14,19 +16,41 @@ type Audio struct {
 	// renders [ ][ ]string
 	Duration int64
 	Format   string
-	args Args
+	Codec string
+	SampleRate int
+	Channels int
+	ChannelLayout string
+	Bitrate int64
+	Tags map[string]string
+	Info *MediaInfo
+	effects *effect
 }
 
 const VOLUME_MULTIPLIER_CAP = 100.0
 
-func pullAudioStats(audioPath string) (duration int64) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "stream=duration", audioPath)
-	cmd.CombinedOutput()
-	// outputLines := strings.Split(string(output), "\n")
-	// for _, line := range outputLines {
-	// 	fmt.Println(line)
-	// }
-	return -1
+// .mka is accepted so the Matroska files ExtractAudio writes for codecs without a container of their own can be loaded back
+var audioExtensions = []string{".mp3", ".aac", ".m4a", ".wav", ".flac", ".ogg", ".opus", ".mka"}
+var audioExtensionsMu sync.RWMutex
+
+// RegisterAudioExtension lets LoadAudio accept files with the given extension, e.g. ".wma".
+func RegisterAudioExtension(extension string) {
+	extension = strings.ToLower(extension)
+	if !strings.HasPrefix(extension, ".") {extension = "." + extension}
+	audioExtensionsMu.Lock()
+	defer audioExtensionsMu.Unlock()
+	for _, val := range audioExtensions {
+		if extension == val {return}
+	}
+	audioExtensions = append(audioExtensions, extension)
+}
+
+func isAudioExtension(extension string) bool {
+	audioExtensionsMu.RLock()
+	defer audioExtensionsMu.RUnlock()
+	for _, val := range audioExtensions {
+		if strings.ToLower(extension) == val {return true}
+	}
+	return false
 }
 
 func (a Audio) GetType() string {
@@ -45,6 +69,18 @@ func (a Audio) GetExtension() string {
 	return filepath.Ext(a.FilePath)
 }
 
+func (a Audio) GetDuration() int64 {
+	return a.Duration
+}
+
+// Length returns the duration of the audio without rounding it to whole seconds.
+func (a Audio) Length() time.Duration {
+	if a.Info != nil && a.Info.Duration > 0 {
+		return a.Info.Duration
+	}
+	return time.Duration(a.Duration) * time.Second
+}
+
 func LoadAudio(audioPath string) (audio Audio, err error) {
 	file, err := os.Stat(audioPath)
 	if err != nil {
@@ -53,124 +89,175 @@ func LoadAudio(audioPath string) (audio Audio, err error) {
 	}
 
 	if file.IsDir() {
-		Logger.Error(fmt.Sprintf(`videoPath: %s is a directory`, audioPath))
-		return Audio{}, errors.New("videoPath: %s is a directory")
+		Logger.Error(fmt.Sprintf(`audioPath: %s is a directory`, audioPath))
+		return Audio{}, fmt.Errorf("audioPath: %s is a directory", audioPath)
+	}
+
+	fileFormat := filepath.Ext(audioPath)
+	if !isAudioExtension(fileFormat) {
+		Logger.Error(fmt.Sprintf(`audioPath: %s | Audio format is not supported`, audioPath))
+		return Audio{}, fmt.Errorf("audioPath: %s | audio format %s is not supported", audioPath, fileFormat)
+	}
+
+	info, err := Probe(audioPath)
+	if err != nil {
+		Logger.Error(fmt.Sprintf(`audioPath: %s | Unable to probe audio | %s`, audioPath, err))
+		return Audio{}, err
 	}
 
-	duration := pullAudioStats(audioPath)
+	stream := info.AudioStream()
+	if stream == nil {
+		Logger.Error(fmt.Sprintf(`audioPath: %s | File has no audio stream`, audioPath))
+		return Audio{}, fmt.Errorf("audioPath: %s has no audio stream", audioPath)
+	}
+
+	duration := info.Duration
+	if duration == 0 {duration = stream.Duration}
+	bitrate := stream.Bitrate
+	if bitrate == 0 {bitrate = info.Bitrate}
+	tags := info.Tags
+	if len(tags) == 0 {tags = stream.Tags}
 
 	return Audio{
 		FileName:    filepath.Base(audioPath),
 		FilePath:    audioPath,
-		Format:      filepath.Ext(audioPath),
-		Duration:    int64(duration),
+		Format:      fileFormat,
+		Duration:    int64(duration.Seconds()),
+		Codec:       stream.Codec,
+		SampleRate:  stream.SampleRate,
+		Channels:    stream.Channels,
+		ChannelLayout: stream.ChannelLayout,
+		Bitrate:     bitrate,
+		Tags:        tags,
+		Info:        info,
 		// renders:        [ ][ ]string{},
-		args: make(Args),
 	}, nil
 }
 
+// withEffect returns a copy of audio with one more effect. audio itself is left untouched.
+func (audio *Audio) withEffect(flag string, arg subArg) *Audio {
+	modifiedAudio := *audio
+	modifiedAudio.effects = audio.effects.push(flag, arg)
+	return &modifiedAudio
+}
+
 func (audio *Audio) Trim(startTime int64, endTime int64) (modifiedAudio *Audio) {
-	if startTime > endTime {
+	return audio.TrimRange(time.Duration(startTime) * time.Second, time.Duration(endTime) * time.Second)
+}
+
+// Same as Trim with sub-second precision.
+func (audio *Audio) TrimRange(start time.Duration, end time.Duration) (modifiedAudio *Audio) {
+	if start > end {
 		Logger.Error("start time cannot be bigger than end time")
 		return &Audio{}
 	}
 	// audio.renders = append(audio.renders, []string{"-filter_complex", fmt.Sprintf(`[0]trim=start=%d:end=%d[aout]`, startTime, endTime), "-map", "[aout]"})
 	//audio.renders = append(audio.renders, []string{"-ss", fmt.Sprintf(`%d`, startTime), "-to", fmt.Sprintf("%d", endTime), "-c:a", "copy"})
-	audio.args.addArg("-ss", 
+	modifiedAudio = audio.withEffect("-ss", 
 		subArg{
 			Key: "ss",
-			Value: fmt.Sprintf(`%d -to %d`, startTime, endTime),
+			Value: fmt.Sprintf(`%f -to %f`, start.Seconds(), end.Seconds()),
 		},
 	)
 
-	return audio
+	return modifiedAudio
 }
 
 func (audio *Audio) ChangeVolume(multiplier float64) (modifiedAudio *Audio) {
 	// audio.renders = append(audio.renders, []string{"-filter:a", fmt.Sprintf(`volume=%f`, multiplier)})
-	audio.args.addArg("-filter:a",
+	modifiedAudio = audio.withEffect("-filter:a",
 		subArg {
 			Key: "volume",
 			Value: fmt.Sprintf(`volume=%f`, multiplier),
 		},
 	)
-	return audio
+	return modifiedAudio
 }
 
 func (audio *Audio) Nightcore() (modifiedAudio *Audio) {
 	// audio.renders = append(audio.renders, []string{"-filter_complex", "asetrate=44100*1.25,atempo=1.25"})
-	audio.args.addArg("-filter_complex", 
+	modifiedAudio = audio.withEffect("-filter_complex", 
 		subArg{
 			Key: "nightcore",
 			Value: "asetrate=44100*1.25,atempo=1.25",
 		},
 	)
-	return audio
+	return modifiedAudio
 }
 
 func (audio *Audio) BassBoost() (modifiedAudio *Audio) {
 	// audio.renders = append(audio.renders, []string{"-af", "equalizer=f=80:width_type=h:width=50:g=12"})
-	audio.args.addArg("-af", 
+	modifiedAudio = audio.withEffect("-af", 
 		subArg{
 			Key: "equalizer",
 			Value: "equalizer=f=80:width_type=h:width=50:g=12",
 		},
 	)
-	return audio
+	return modifiedAudio
 }
 
 func (audio *Audio) SpeedUp(multiplier float64) (modifiedAudio *Audio) {
 	// audio.renders = append(audio.renders, []string{"-filter:a", fmt.Sprintf(`atemp=%f`, multiplier)})
-	audio.args.addArg("-filter:a", 
+	modifiedAudio = audio.withEffect("-filter:a", 
 		subArg{
 			Key: "speedup",
 			Value: fmt.Sprintf(`atemp=%f`, multiplier),
 		},
 	)
-	return audio
+	return modifiedAudio
 }
 
 
-// func (audio Audio) AddBackgroundImage(imagePath string, outputPath string) {
-// 	cmd := exec.Command("ffmpeg", "-loop", "1", "-i", imagePath, "-i", audio.FilePath, "-vf", "crop=in_w*9/16,scale=1920x1080", "-c:v", "libx264", "-c:a", "copy", "-shortest", outputPath)
-// 	Logger.Infof(`Adding background`)
-// 	combinedOutput, err := cmd.CombinedOutput()
-// 	if err != nil {
-// 		Logger.Error(string(combinedOutput))
-// 	}
-// 	Logger.Infof(`Background has been added`)
-// }
+func (audio Audio) Render(outputPath string) (outputAudio Audio) {
+	outputAudio, err := audio.RenderE(outputPath)
+	if err != nil {
+		return audio
+	}
+	return outputAudio
+}
 
-// func (audio Audio) AddBackgroundVideo(videoPath string, outputPath string) {
-// 	cmd := exec.Command("ffmpeg", "-i", videoPath, "-i", audio.FilePath, "-map", "0:v", "-map", "1:a", "-c:v", "copy", "-y", outputPath)
-// 	Logger.Infof(`Adding background`)
-// 	combinedOutput, err := cmd.CombinedOutput()
-// 	if err != nil {
-// 		Logger.Error(string(combinedOutput))
-// 	}
-// 	Logger.Infof(`Background has been added`)
-// }
+// Same as Render but returns an error instead of the input audio when rendering fails.
+func (audio Audio) RenderE(outputPath string) (outputAudio Audio, err error) {
+	return audio.RenderContext(context.Background(), outputPath)
+}
 
+// Same as RenderE but stops the active ffmpeg stage as soon as ctx is cancelled and returns ctx.Err().
+// Options such as WithProgress customize the render.
+func (audio Audio) RenderContext(ctx context.Context, outputPath string, options ...RenderOption) (outputAudio Audio, err error) {
+	settings := newRenderSettings(options)
+	plan, err := audio.plan(outputPath, settings)
+	if err != nil {
+		return audio, err
+	}
 
- 
-func (audio Audio) Render(outputPath string) (outputAudio Audio) {
-	removeIfExists(outputPath)
-	// if videoEncoding == "" {videoEncoding = VIDEO_ENCODINGS.Best}
+	err = startRender(ctx, plan, settings)
+	if err != nil {
+		return audio, err
+	}
+	return LoadAudio(outputPath)
+}
+
+// Returns the ffmpeg invocations Render would run for the effects applied so far, without running them.
+// Planning does not consume the applied effects.
+func (audio Audio) Plan(outputPath string, options ...RenderOption) (RenderPlan, error) {
+	return audio.plan(outputPath, newRenderSettings(options))
+}
 
-	g := GetRenderGraph(AudioGraph)
-	renderStages := g.ProduceOrdering(audio.args, &audio)
+func (audio Audio) plan(outputPath string, settings renderSettings) (RenderPlan, error) {
+	if settings.encode != nil {
+		if err := settings.encode.Validate(outputPath); err != nil {
+			Logger.Errorf("outputPath: %s | %s", outputPath, err)
+			return RenderPlan{}, err
+		}
+	}
+
+	renderStages := collapseStages(audio.effects.list(), &audio)
 	
 	if len(renderStages) == 0 {
 		Logger.Errorf("No effects applied. Aborting render.\n")
-		return audio
+		return RenderPlan{}, ErrNoEffects
 	}
 
 	// fmt.Printf("\nALL STAGES %+v\n", renderStages)
-	startRender(&renderStages, audio, outputPath)
-	outputAudio , err := LoadAudio(outputPath)
-
-	if err != nil {
-		return audio
-	}
-	return outputAudio
-}
+	return buildRenderPlan(renderStages[0], audio, outputPath, settings), nil
+}
\ No newline at end of file
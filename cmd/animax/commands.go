@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pichan321/animax"
+	"github.com/pichan321/animax/recipe"
+	util "github.com/pichan321/animax/utilities"
+)
+
+type videoEffect func(video *animax.Video) (*animax.Video, error)
+type audioEffect func(audio *animax.Audio) (*animax.Audio, error)
+
+func runProbe(ctx context.Context, cli *cli, args []string) (result, error) {
+	positional, err := cli.parse(args, 1)
+	if err != nil {
+		return result{}, err
+	}
+	info, err := animax.ProbeContext(ctx, positional[0])
+	if err != nil {
+		return result{}, err
+	}
+	return result{Probe: info, Duration: info.Duration.Seconds()}, nil
+}
+
+func runTrim(ctx context.Context, cli *cli, args []string) (result, error) {
+	start := cli.flags.String("start", "0", "start of the section, in seconds or as a timecode (HH:MM:SS.mmm, f<frame>)")
+	end := cli.flags.String("end", "", "end of the section, in seconds or as a timecode")
+	noEncode := cli.flags.Bool("no-encode", false, "stream copy from the keyframe before start instead of re-encoding")
+	smart := cli.flags.Bool("smart", false, "re-encode only up to the first keyframe after start and stream copy the rest")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if *end == "" {
+		return result{}, fmt.Errorf("%w: --end is required", errUsage)
+	}
+	if *noEncode && *smart {
+		return result{}, fmt.Errorf("%w: --no-encode and --smart cannot be combined", errUsage)
+	}
+	input, output := positional[0], positional[1]
+
+	if !*noEncode && !*smart {
+		return cli.renderChain(ctx, input, output, *encoding,
+			func(video *animax.Video) (*animax.Video, error) {
+				startTime, endTime, err := videoRange(*video, *start, *end)
+				if err != nil {
+					return nil, err
+				}
+				return video.TrimRange(startTime, endTime), nil
+			},
+			func(audio *animax.Audio) (*animax.Audio, error) {
+				startTime, err := animax.ParseTimecode(*start, 0)
+				if err != nil {
+					return nil, err
+				}
+				endTime, err := animax.ParseTimecode(*end, 0)
+				if err != nil {
+					return nil, err
+				}
+				return audio.TrimRange(startTime, endTime), nil
+			})
+	}
+
+	return cli.writeFile(ctx, output, func(output string) error {
+		video, err := animax.LoadVideo(input)
+		if err != nil {
+			return err
+		}
+		startTime, endTime, err := videoRange(video, *start, *end)
+		if err != nil {
+			return err
+		}
+		if *smart {
+			_, err = util.SmartTrimContext(ctx, video, startTime, endTime, output)
+		} else {
+			_, err = util.TrimNoEncodeRangeContext(ctx, video, startTime, endTime, output)
+		}
+		return err
+	})
+}
+
+func runResize(ctx context.Context, cli *cli, args []string) (result, error) {
+	width := cli.flags.Int64("width", 0, "new width, the height follows the aspect ratio when omitted")
+	height := cli.flags.Int64("height", 0, "new height, the width follows the aspect ratio when omitted")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if *width <= 0 && *height <= 0 {
+		return result{}, fmt.Errorf("%w: --width or --height is required", errUsage)
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding, func(video *animax.Video) (*animax.Video, error) {
+		switch {
+		case *height <= 0:
+			return video.ResizeByWidth(*width), nil
+		case *width <= 0:
+			return video.ResizeByHeight(*height), nil
+		}
+		return video.Resize(*width, *height), nil
+	}, nil)
+}
+
+func runCrop(ctx context.Context, cli *cli, args []string) (result, error) {
+	width := cli.flags.Int64("width", 0, "width of the cropped area")
+	height := cli.flags.Int64("height", 0, "height of the cropped area")
+	x := cli.flags.Int64("x", 0, "left edge of the cropped area")
+	y := cli.flags.Int64("y", 0, "top edge of the cropped area")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if *width <= 0 || *height <= 0 {
+		return result{}, fmt.Errorf("%w: --width and --height are required", errUsage)
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding, func(video *animax.Video) (*animax.Video, error) {
+		return video.Crop(*width, *height, *x, *y), nil
+	}, nil)
+}
+
+func runBlur(ctx context.Context, cli *cli, args []string) (result, error) {
+	intensity := cli.flags.Int("intensity", 5, "blur radius, between 0 and 50")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if *intensity < 0 || *intensity > 50 {
+		return result{}, fmt.Errorf("%w: --intensity should be between 0 and 50", errUsage)
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding, func(video *animax.Video) (*animax.Video, error) {
+		return video.Blur(int16(*intensity)), nil
+	}, nil)
+}
+
+func runText(ctx context.Context, cli *cli, args []string) (result, error) {
+	overlay := animax.TextOverlay{}
+	cli.flags.StringVar(&overlay.Text, "text", "", "text to draw")
+	cli.flags.StringVar(&overlay.FontFile, "font", "", ".ttf or .otf font file")
+	cli.flags.IntVar(&overlay.FontSize, "size", 48, "font size in pixels")
+	cli.flags.StringVar(&overlay.Color, "color", "white", "text color, e.g. white, #ffcc00 or black@0.5")
+	cli.flags.IntVar(&overlay.BorderWidth, "border", 0, "width of the border around the letters")
+	cli.flags.BoolVar(&overlay.Box, "box", false, "draw a box behind the text")
+	cli.flags.StringVar(&overlay.Position, "position", animax.TEXT_POSITIONS.BottomCenter, "top-left, top-center, ..., center, ..., bottom-right")
+	start := cli.flags.String("start", "0", "when the text appears, in seconds or as a timecode")
+	end := cli.flags.String("end", "0", "when the text disappears, 0 keeps it until the end")
+	fade := cli.flags.Duration("fade", 0, "fade in and out duration, e.g. 500ms")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if overlay.Text == "" {
+		return result{}, fmt.Errorf("%w: --text is required", errUsage)
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding, func(video *animax.Video) (*animax.Video, error) {
+		if overlay.Start, err = video.ParseTimecode(*start); err != nil {
+			return nil, err
+		}
+		if overlay.End, err = video.ParseTimecode(*end); err != nil {
+			return nil, err
+		}
+		overlay.FadeIn, overlay.FadeOut = *fade, *fade
+		text := video.DrawText(overlay)
+		if text.FilePath == "" {
+			return nil, fmt.Errorf("%w: unknown --position %q", errUsage, overlay.Position)
+		}
+		return text, nil
+	}, nil)
+}
+
+func runSubtitles(ctx context.Context, cli *cli, args []string) (result, error) {
+	file := cli.flags.String("file", "", "SRT, WebVTT or ASS file timed to the input")
+	burn := cli.flags.Bool("burn", false, "draw the subtitles onto the video instead of adding a track")
+	language := cli.flags.String("language", "", "language of the subtitle track, e.g. eng")
+	style := animax.SubtitleStyle{}
+	cli.flags.StringVar(&style.FontName, "font", "", "font name, for --burn")
+	cli.flags.IntVar(&style.FontSize, "size", 0, "font size, for --burn")
+	cli.flags.StringVar(&style.Color, "color", "", "text color as #RRGGBB, for --burn")
+	cli.flags.StringVar(&style.Position, "position", "", "top-left, top-center, ..., bottom-right, for --burn")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if *file == "" {
+		return result{}, fmt.Errorf("%w: --file is required", errUsage)
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding, func(video *animax.Video) (*animax.Video, error) {
+		var subtitled *animax.Video
+		if *burn {
+			subtitled = video.BurnSubtitles(*file, style)
+		} else {
+			subtitled = video.AddSubtitleTrack(*file, *language)
+		}
+		if subtitled.FilePath == "" {
+			return nil, fmt.Errorf("unable to add the subtitles of %s", *file)
+		}
+		return subtitled, nil
+	}, nil)
+}
+
+func runSaturate(ctx context.Context, cli *cli, args []string) (result, error) {
+	multiplier := cli.flags.Float64("multiplier", 1.5, "saturation multiplier, 1 keeps the original colors")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding, func(video *animax.Video) (*animax.Video, error) {
+		return video.Saturate(*multiplier), nil
+	}, nil)
+}
+
+func runVolume(ctx context.Context, cli *cli, args []string) (result, error) {
+	multiplier := cli.flags.Float64("multiplier", 1, "volume multiplier, 0 mutes")
+	encoding := encodingFlag(cli)
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	return cli.renderChain(ctx, positional[0], positional[1], *encoding,
+		func(video *animax.Video) (*animax.Video, error) {
+			return video.ChangeVolume(*multiplier), nil
+		},
+		func(audio *animax.Audio) (*animax.Audio, error) {
+			return audio.ChangeVolume(*multiplier), nil
+		})
+}
+
+func runConcat(ctx context.Context, cli *cli, args []string) (result, error) {
+	encode := cli.flags.Bool("encode", false, "re-encode instead of stream copying, needed when the inputs use different codecs")
+	positional, err := cli.parse(args, -3)
+	if err != nil {
+		return result{}, err
+	}
+	return cli.writeFile(ctx, positional[0], func(output string) error {
+		videos := []animax.Video{}
+		for _, input := range positional[1:] {
+			video, err := animax.LoadVideo(input)
+			if err != nil {
+				return err
+			}
+			videos = append(videos, video)
+		}
+		return util.ConcatenateVideosContext(ctx, videos, *encode, output)
+	})
+}
+
+func runConcatDir(ctx context.Context, cli *cli, args []string) (result, error) {
+	encode := cli.flags.Bool("encode", false, "re-encode instead of stream copying, needed when the inputs use different codecs")
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	return cli.writeFile(ctx, positional[1], func(output string) error {
+		return util.ConcatenateVideosFromDirContext(ctx, positional[0], *encode, output)
+	})
+}
+
+func runSkipper(ctx context.Context, cli *cli, args []string) (result, error) {
+	skip := cli.flags.Float64("skip", 0, "seconds to skip every time")
+	interval := cli.flags.Float64("interval", 0, "seconds to keep between two skips")
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	if *skip <= 0 || *interval <= 0 {
+		return result{}, fmt.Errorf("%w: --skip and --interval are required", errUsage)
+	}
+	return cli.writeFile(ctx, positional[1], func(output string) error {
+		video, err := animax.LoadVideo(positional[0])
+		if err != nil {
+			return err
+		}
+		return util.SkipperContext(ctx, video, *skip, *interval, output)
+	})
+}
+
+func runOverlayBackground(ctx context.Context, cli *cli, args []string) (result, error) {
+	logo := cli.flags.String("logo", "", "image to put in the bottom left corner")
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	return cli.writeFile(ctx, positional[1], func(output string) error {
+		video, err := animax.LoadVideo(positional[0])
+		if err != nil {
+			return err
+		}
+		if *logo != "" {
+			return util.AddOverlayBackgroundAndLogoContext(ctx, video, *logo, output)
+		}
+		return util.AddOverlayBackgroundContext(ctx, video, output)
+	})
+}
+
+func runScreenshot(ctx context.Context, cli *cli, args []string) (result, error) {
+	at := cli.flags.String("at", "0", "time of the frame, in seconds or as a timecode")
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	write := func(output string) error {
+		video, err := animax.LoadVideo(positional[0])
+		if err != nil {
+			return err
+		}
+		timestamp, err := video.ParseTimecode(*at)
+		if err != nil {
+			return err
+		}
+		return util.TakeScreenshotContext(ctx, video.FilePath, timestamp.Seconds(), output)
+	}
+	if cli.dryRun {
+		return cli.dryRunFiles(positional[1], write)
+	}
+	if err := write(positional[1]); err != nil {
+		return result{}, err
+	}
+	return result{Output: positional[1]}, nil
+}
+
+func runExtractAudio(ctx context.Context, cli *cli, args []string) (result, error) {
+	options := util.ExtractAudioOptions{}
+	cli.flags.IntVar(&options.Track, "track", 0, "position of the audio track among the audio streams, starting at 0")
+	cli.flags.StringVar(&options.Language, "language", "", "language tag of the audio track, e.g. eng, instead of --track")
+	cli.flags.StringVar(&options.Codec, "codec", "", "encoder to transcode with; the stream is copied when empty and the container allows it")
+	cli.flags.StringVar(&options.Bitrate, "bitrate", "", "audio bitrate when transcoding, e.g. 192k")
+	positional, err := cli.parse(args, 2)
+	if err != nil {
+		return result{}, err
+	}
+	var audio animax.Audio
+	write := func(output string) (err error) {
+		audio, err = util.ExtractAudioContext(ctx, positional[0], output, options)
+		return err
+	}
+	if cli.dryRun {
+		return cli.dryRunFiles(positional[1], write)
+	}
+	if err := write(positional[1]); err != nil {
+		return result{}, err
+	}
+	return result{Output: audio.FilePath, Duration: audio.Length().Seconds()}, nil
+}
+
+func runRender(ctx context.Context, cli *cli, args []string) (result, error) {
+	recipePath := cli.flags.String("recipe", "", "JSON or YAML recipe to apply")
+	if _, err := cli.parse(args, 0); err != nil {
+		return result{}, err
+	}
+	if *recipePath == "" {
+		return result{}, fmt.Errorf("%w: --recipe is required", errUsage)
+	}
+
+	edit, err := recipe.LoadRecipe(*recipePath)
+	if err != nil {
+		return result{}, err
+	}
+	if cli.dryRun {
+		plan, err := edit.Plan()
+		if err != nil {
+			return result{}, err
+		}
+		return result{Plan: &plan}, nil
+	}
+
+	output, err := recipe.ApplyRecipeContext(ctx, edit, cli.renderOptions()...)
+	cli.endProgress()
+	if err != nil {
+		return result{}, err
+	}
+	return result{Output: output.GetFilePath(), Duration: float64(output.GetDuration())}, nil
+}
+
+func runUpload(ctx context.Context, cli *cli, args []string) (result, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return result{}, fmt.Errorf("%w: expected facebook-reel or facebook-video", errUsage)
+	}
+	target := args[0]
+	pageID := cli.flags.String("page-id", "", "id of the Facebook page")
+	token := cli.flags.String("token", os.Getenv("ANIMAX_FACEBOOK_TOKEN"), "page access token, defaults to $ANIMAX_FACEBOOK_TOKEN")
+	title := cli.flags.String("title", "", "title of the video")
+	description := cli.flags.String("description", "", "description of the video")
+	positional, err := cli.parse(args[1:], 1)
+	if err != nil {
+		return result{}, err
+	}
+	if *pageID == "" || *token == "" {
+		return result{}, fmt.Errorf("%w: --page-id and --token are required", errUsage)
+	}
+	upload := util.PageUpload{
+		FilePath:    positional[0],
+		Title:       *title,
+		Description: *description,
+		Token:       *token,
+		PageId:      *pageID,
+	}
+	switch {
+	case target == "facebook-reel" && cli.dryRun:
+		return steps(upload.ReelSteps())
+	case target == "facebook-video" && cli.dryRun:
+		return steps(upload.VideoSteps())
+	case target == "facebook-reel":
+		err = util.UploadToFacebookReelPage(upload)
+	case target == "facebook-video":
+		err = util.UploadToFacebookVideoPage(upload)
+	default:
+		return result{}, fmt.Errorf("%w: unknown upload target %q, expected facebook-reel or facebook-video", errUsage, target)
+	}
+	if err != nil {
+		return result{}, err
+	}
+	return result{Message: fmt.Sprintf("uploaded %s to page %s", positional[0], *pageID)}, nil
+}
+
+/*
+	renderChain loads input, applies the effect matching its type and renders the result to output.
+	With --dry-run the plan is returned instead. A nil audio effect means the command only works on videos.
+*/
+func (cli *cli) renderChain(ctx context.Context, input string, output string, encoding string, onVideo videoEffect, onAudio audioEffect) (result, error) {
+	switch animax.FileType(input) {
+	case "video":
+		source, err := animax.LoadVideo(input)
+		if err != nil {
+			return result{}, err
+		}
+		modified, err := onVideo(&source)
+		if err != nil {
+			return result{}, err
+		}
+		if cli.dryRun {
+			plan, err := modified.Plan(output, encoderName(encoding))
+			if err != nil {
+				return result{}, err
+			}
+			return result{Plan: &plan}, nil
+		}
+		rendered, err := modified.RenderContext(ctx, output, encoderName(encoding), cli.renderOptions()...)
+		cli.endProgress()
+		if err != nil {
+			return result{}, err
+		}
+		return result{Output: rendered.FilePath, Duration: rendered.Length().Seconds()}, nil
+	case "audio":
+		if onAudio == nil {
+			return result{}, fmt.Errorf("%s only applies to videos", cli.flags.Name())
+		}
+		source, err := animax.LoadAudio(input)
+		if err != nil {
+			return result{}, err
+		}
+		modified, err := onAudio(&source)
+		if err != nil {
+			return result{}, err
+		}
+		if cli.dryRun {
+			plan, err := modified.Plan(output)
+			if err != nil {
+				return result{}, err
+			}
+			return result{Plan: &plan}, nil
+		}
+		rendered, err := modified.RenderContext(ctx, output, cli.renderOptions()...)
+		cli.endProgress()
+		if err != nil {
+			return result{}, err
+		}
+		return result{Output: rendered.FilePath, Duration: rendered.Length().Seconds()}, nil
+	}
+	return result{}, fmt.Errorf("%s is neither a supported video nor audio file", input)
+}
+
+// renderOptions reports progress on stderr unless the output is JSON.
+func (cli *cli) renderOptions() []animax.RenderOption {
+	if cli.json {
+		return nil
+	}
+	return []animax.RenderOption{animax.WithProgress(func(progress animax.Progress) {
+		fmt.Fprintf(cli.stderr, "\rstage %d/%d %5.1f%% %.2fx", progress.Stage+1, progress.Stages, progress.Percent, progress.Speed)
+	})}
+}
+
+func (cli *cli) endProgress() {
+	if !cli.json {
+		fmt.Fprintln(cli.stderr)
+	}
+}
+
+func encodingFlag(cli *cli) *string {
+	return cli.flags.String("encoding", "best", "best (H.264), efficient (VP9), compressed (AV1) or the name of an ffmpeg encoder")
+}
+
+func encoderName(encoding string) string {
+	switch strings.ToLower(encoding) {
+	case "", "best":
+		return animax.VIDEO_ENCODINGS.Best
+	case "efficient":
+		return animax.VIDEO_ENCODINGS.Efficient
+	case "compressed":
+		return animax.VIDEO_ENCODINGS.Compressed
+	}
+	return encoding
+}
+
+func videoRange(video animax.Video, start string, end string) (time.Duration, time.Duration, error) {
+	startTime, err := video.ParseTimecode(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	endTime, err := video.ParseTimecode(end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if startTime > endTime {
+		return 0, 0, errors.New("start cannot be after end")
+	}
+	return startTime, endTime, nil
+}
+
+// writeFile runs write, which writes output with the utilities, and describes output. With --dry-run the ffmpeg invocations are returned instead.
+func (cli *cli) writeFile(ctx context.Context, output string, write func(output string) error) (result, error) {
+	if cli.dryRun {
+		return cli.dryRunFiles(output, write)
+	}
+	if err := write(output); err != nil {
+		return result{}, err
+	}
+	return describe(ctx, output)
+}
+
+func steps(steps []string, err error) (result, error) {
+	if err != nil {
+		return result{}, err
+	}
+	return result{Steps: steps}, nil
+}
+
+// describe reports the duration of a file written by a utility.
+func describe(ctx context.Context, output string) (result, error) {
+	info, err := animax.ProbeContext(ctx, output)
+	if err != nil {
+		return result{Output: output}, nil
+	}
+	return result{Output: output, Duration: info.Duration.Seconds()}, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pichan321/animax"
+)
+
+/*
+	dryRun records the ffmpeg invocations of the commands built on the utilities instead of running them. ffprobe still
+	runs, except on the files a recorded invocation would have written, which are described like the media they come from.
+	The later steps of a utility load those files, so an empty placeholder stands in for each of them.
+*/
+type dryRun struct {
+	executor animax.Executor
+
+	mu       sync.Mutex
+	commands [][]string
+	sources  map[string]string // file written by a recorded invocation -> media it is made from
+}
+
+func (dry *dryRun) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(args) == 0 {
+		return dry.executor.Run(ctx, name, args)
+	}
+
+	dry.mu.Lock()
+	defer dry.mu.Unlock()
+	last := args[len(args)-1]
+	if name != "ffmpeg" {
+		if source, ok := dry.sources[last]; ok {
+			args = append(append([]string{}, args[:len(args)-1]...), source)
+		}
+		return dry.executor.Run(ctx, name, args)
+	}
+
+	dry.commands = append(dry.commands, append([]string{name}, args...))
+	if last != os.DevNull {
+		dry.sources[last] = dry.source(args)
+		if _, err := os.Stat(last); os.IsNotExist(err) {
+			os.WriteFile(last, nil, 0644)
+		}
+	}
+	return nil, nil, nil
+}
+
+// source returns the media the output of args is made from: its first input, or the first file of a concat list.
+func (dry *dryRun) source(args []string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] != "-i" {
+			continue
+		}
+		input := args[i+1]
+		if i >= 2 && args[i-2] == "-f" && args[i-1] == "concat" {
+			input = firstListed(input)
+		}
+		if source, ok := dry.sources[input]; ok {
+			return source
+		}
+		return input
+	}
+	return ""
+}
+
+// firstListed returns the first file of an ffmpeg concat list.
+func firstListed(list string) string {
+	file, err := os.Open(list)
+	if err != nil {
+		return list
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "file "); ok {
+			return strings.Trim(path, "'")
+		}
+	}
+	return list
+}
+
+/*
+	dryRunFiles runs write, which writes output with the utilities, through a dryRun executor and returns the ffmpeg
+	invocations it would make. write gets a path in a temporary directory instead of output, so no file of the user is
+	replaced, and the invocations are printed with output.
+*/
+func (cli *cli) dryRunFiles(output string, write func(output string) error) (result, error) {
+	dir, err := os.MkdirTemp("", "animax-dry-run")
+	if err != nil {
+		return result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	dry := &dryRun{executor: animax.DefaultExecutor, sources: make(map[string]string)}
+	animax.DefaultExecutor = dry
+	defer func() { animax.DefaultExecutor = dry.executor }()
+
+	placeholder := filepath.Join(dir, filepath.Base(output))
+	if err := write(placeholder); err != nil {
+		return result{}, err
+	}
+
+	replacer := strings.NewReplacer(placeholder, output)
+	commands := [][]string{}
+	for _, command := range dry.commands {
+		args := []string{}
+		for _, arg := range command {
+			args = append(args, replacer.Replace(arg))
+		}
+		commands = append(commands, args)
+	}
+	return result{Output: output, Commands: commands}, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/pichan321/animax"
+	"github.com/sirupsen/logrus"
+)
+
+// errUsage is returned by commands called with missing or invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+// errFlags is returned for flags the flag package rejected, once it has printed the error and the usage.
+var errFlags = errors.New("invalid flags")
+
+// command is a subcommand of the animax tool.
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, cli *cli, args []string) (result, error)
+}
+
+var commands = map[string]command{
+	"probe":         {"probe <file>", "print the streams, format and chapters of a media file", runProbe},
+	"trim":          {"trim [--no-encode|--smart] --start T --end T <input> <output>", "cut a section out of a video or an audio file", runTrim},
+	"resize":        {"resize [--width W] [--height H] <input> <output>", "scale a video, keeping its aspect ratio when only one side is given", runResize},
+	"crop":          {"crop --width W --height H [--x X] [--y Y] <input> <output>", "crop a video", runCrop},
+	"blur":          {"blur --intensity N <input> <output>", "box blur a video", runBlur},
+	"text":          {"text --text T [--position P] [--size N] [--font F] [--start T] [--end T] <input> <output>", "draw a title or a caption over a video", runText},
+	"subtitles":     {"subtitles --file F [--burn] [--language L] <input> <output>", "add a subtitle track to a video, or burn the subtitles onto it", runSubtitles},
+	"saturate":      {"saturate --multiplier M <input> <output>", "change the saturation of a video", runSaturate},
+	"volume":        {"volume --multiplier M <input> <output>", "change the volume of a video or an audio file", runVolume},
+	"concat":        {"concat [--encode] <output> <input>...", "join videos one after the other", runConcat},
+	"concat-dir":    {"concat-dir [--encode] <directory> <output>", "join every video of a directory", runConcatDir},
+	"skipper":       {"skipper --skip S --interval I <input> <output>", "keep I seconds, skip S seconds, repeatedly", runSkipper},
+	"overlay-bg":    {"overlay-bg [--logo image] <input> <output>", "put a video over a 9:16 blurred background of itself", runOverlayBackground},
+	"screenshot":    {"screenshot --at T <input> <output>", "save a single frame as an image", runScreenshot},
+	"extract-audio": {"extract-audio [--track N|--language L] [--codec C] <input> <output>", "save an audio track of a video, copied when possible", runExtractAudio},
+	"render":        {"render --recipe <file>", "apply a JSON or YAML recipe", runRender},
+	"upload":        {"upload facebook-reel|facebook-video --page-id ID --token TOKEN <file>", "upload a video to a Facebook page", runUpload},
+}
+
+// cli holds the flags every command accepts.
+type cli struct {
+	flags   *flag.FlagSet
+	json    bool
+	dryRun  bool
+	verbose bool
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+/*
+	result is what a command reports once done. Text output prints the non-empty fields,
+	--json prints the whole struct.
+*/
+type result struct {
+	Command  string             `json:"command"`
+	Output   string             `json:"output,omitempty"`
+	Duration float64            `json:"duration,omitempty"` // seconds
+	Plan     *animax.RenderPlan `json:"plan,omitempty"`
+	Commands [][]string         `json:"commands,omitempty"` // ffmpeg invocations printed by --dry-run for the commands built on the utilities
+	Steps    []string           `json:"steps,omitempty"`    // requests printed by upload --dry-run
+	Probe    *animax.MediaInfo  `json:"probe,omitempty"`
+	Message  string             `json:"message,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line args and returns the exit code: 0 on success, 1 when the command fails and 2 on misuse.
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	// stdout is kept for results so the output can be piped into other tools
+	animax.Logger.SetOutput(stderr)
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		return 2
+	}
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "animax: unknown command %q\n\n", name)
+		printUsage(stderr)
+		return 2
+	}
+
+	cli := &cli{flags: flag.NewFlagSet(name, flag.ContinueOnError), stdout: stdout, stderr: stderr}
+	cli.flags.SetOutput(stderr)
+	cli.flags.BoolVar(&cli.json, "json", false, "print the result as JSON")
+	cli.flags.BoolVar(&cli.dryRun, "dry-run", false, "print the ffmpeg invocations instead of running them")
+	cli.flags.BoolVar(&cli.verbose, "verbose", false, "log every step")
+	cli.flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: animax %s\n\n%s\n\n", cmd.usage, cmd.help)
+		cli.flags.PrintDefaults()
+	}
+
+	animax.Logger.SetLevel(logrus.WarnLevel)
+	res, err := cmd.run(ctx, cli, args[1:])
+	res.Command = name
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, errFlags) {
+		return 2
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(stderr, "animax %s: %s\n", name, err)
+		cli.flags.Usage()
+		return 2
+	}
+	if err != nil {
+		res.Error = err.Error()
+		if cli.json {
+			cli.print(res)
+		} else {
+			fmt.Fprintf(stderr, "animax %s: %s\n", name, err)
+		}
+		return 1
+	}
+	cli.print(res)
+	return 0
+}
+
+// parse parses the flags of the command and checks it got exactly count positional arguments, or at least count when count is negative.
+func (cli *cli) parse(args []string, count int) ([]string, error) {
+	if err := cli.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", errFlags, err)
+	}
+	if cli.verbose {
+		animax.Logger.SetLevel(logrus.InfoLevel)
+	}
+
+	positional := cli.flags.Args()
+	if (count >= 0 && len(positional) != count) || (count < 0 && len(positional) < -count) {
+		return nil, fmt.Errorf("%w: expected %s arguments, got %d", errUsage, expected(count), len(positional))
+	}
+	return positional, nil
+}
+
+func expected(count int) string {
+	if count < 0 {
+		return fmt.Sprintf("at least %d", -count)
+	}
+	return fmt.Sprint(count)
+}
+
+func (cli *cli) print(res result) {
+	if cli.json {
+		encoder := json.NewEncoder(cli.stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(res)
+		return
+	}
+
+	switch {
+	case res.Plan != nil:
+		fmt.Fprint(cli.stdout, res.Plan.String())
+	case len(res.Commands) > 0:
+		for _, command := range res.Commands {
+			fmt.Fprintln(cli.stdout, strings.Join(command, " "))
+		}
+	case len(res.Steps) > 0:
+		fmt.Fprintln(cli.stdout, strings.Join(res.Steps, "\n"))
+	case res.Probe != nil:
+		printProbe(cli.stdout, res.Probe)
+	case res.Output != "":
+		fmt.Fprintln(cli.stdout, res.Output)
+	}
+	if res.Message != "" {
+		fmt.Fprintln(cli.stdout, res.Message)
+	}
+}
+
+func printProbe(w io.Writer, info *animax.MediaInfo) {
+	fmt.Fprintf(w, "%s: %s, %s, %d bytes, %d b/s\n", info.Path, info.FormatName, info.Duration, info.Size, info.Bitrate)
+	for _, stream := range info.Streams {
+		details := []string{stream.Codec}
+		switch stream.Type {
+		case "video":
+			details = append(details, fmt.Sprintf("%dx%d", stream.Width, stream.Height), fmt.Sprintf("%.3f fps", stream.FrameRate), stream.PixelFormat)
+		case "audio":
+			details = append(details, fmt.Sprintf("%d Hz", stream.SampleRate), fmt.Sprintf("%d channels", stream.Channels))
+		}
+		if stream.Language != "" {
+			details = append(details, stream.Language)
+		}
+		fmt.Fprintf(w, "  #%d %s: %s\n", stream.Index, stream.Type, strings.Join(details, ", "))
+	}
+	for _, chapter := range info.Chapters {
+		fmt.Fprintf(w, "  chapter %s - %s: %s\n", chapter.Start, chapter.End, chapter.Title)
+	}
+}
+
+func printUsage(w io.Writer) {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: animax <command> [--json] [--dry-run] [--verbose] [flags] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-13s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintln(w, "\nRun 'animax <command> --help' for the flags of a command.")
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pichan321/animax"
+)
+
+const videoProbe = `{"streams": [{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "r_frame_rate": "30/1", "avg_frame_rate": "30/1", "nb_frames": "3600"}, {"index": 1, "codec_type": "audio", "codec_name": "aac", "sample_rate": "44100", "channels": 2}], "format": {"duration": "120.0"}}`
+
+// newRecorder answers ffprobe with a 2 minute video with a keyframe every 2 seconds, and records ffmpeg.
+func newRecorder(t *testing.T) *animax.RecordingExecutor {
+	recorder := &animax.RecordingExecutor{
+		CreateOutputs: true,
+		Handler: func(cmd animax.Command) animax.Response {
+			if cmd.Name != "ffprobe" {
+				return animax.Response{}
+			}
+			if strings.Contains(strings.Join(cmd.Args, " "), "packet=pts_time,flags") {
+				packets := ""
+				for second := 0; second < 120; second += 2 {
+					packets += fmt.Sprintf("%d.000000,K_\n%d.500000,__\n", second, second)
+				}
+				return animax.Response{Stdout: []byte(packets)}
+			}
+			return animax.Response{Stdout: []byte(videoProbe)}
+		},
+	}
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+	t.Cleanup(func() { animax.DefaultExecutor = executor })
+	return recorder
+}
+
+func touch(t *testing.T, dir string, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("media"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestExitCodes(t *testing.T) {
+	newRecorder(t)
+	dir := t.TempDir()
+	input := touch(t, dir, "input.mp4")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"success", []string{"probe", input}, 0},
+		{"missing input", []string{"blur", filepath.Join(dir, "missing.mp4"), filepath.Join(dir, "out.mp4")}, 1},
+		{"invalid plan", []string{"blur", input, filepath.Join(dir, "out.webm")}, 1},
+		{"no command", nil, 2},
+		{"unknown command", []string{"sharpen", input}, 2},
+		{"missing argument", []string{"blur", input}, 2},
+		{"unknown flag", []string{"blur", "--radius", "3", input, filepath.Join(dir, "out.mp4")}, 2},
+		{"out of range flag", []string{"blur", "--intensity", "80", input, filepath.Join(dir, "out.mp4")}, 2},
+		{"help", []string{"trim", "--help"}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, stdout, stderr := runCLI(test.args...); code != test.code {
+				t.Errorf("exit code %d, want %d\nstdout: %s\nstderr: %s", code, test.code, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	recorder := newRecorder(t)
+	dir := t.TempDir()
+	input := touch(t, dir, "input.mp4")
+	output := filepath.Join(dir, "blurred.mp4")
+
+	code, stdout, _ := runCLI("blur", "--json", "--intensity", "3", input, output)
+	if code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	var done map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &done); err != nil {
+		t.Fatalf("stdout is not JSON: %s\n%s", err, stdout)
+	}
+	if done["command"] != "blur" || done["output"] != output || done["duration"] != 120.0 || done["error"] != nil {
+		t.Errorf("got %v, want the command, output and duration without an error", done)
+	}
+
+	code, stdout, _ = runCLI("blur", "--json", filepath.Join(dir, "missing.mp4"), output)
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	var failed map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &failed); err != nil {
+		t.Fatalf("stdout is not JSON: %s\n%s", err, stdout)
+	}
+	if failed["command"] != "blur" || failed["error"] == nil || failed["output"] != nil {
+		t.Errorf("got %v, want the command and its error", failed)
+	}
+
+	recorder.Reset()
+	code, stdout, _ = runCLI("blur", "--json", "--dry-run", input, output)
+	var planned struct {
+		Plan struct {
+			Stages []struct{ Args []string }
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &planned); code != 0 || err != nil || len(planned.Plan.Stages) != 1 {
+		t.Errorf("dry run printed %s (exit code %d), want a plan of one stage", stdout, code)
+	}
+	for _, call := range recorder.Calls() {
+		if call.Name == "ffmpeg" {
+			t.Errorf("dry run ran ffmpeg %q", call.Args)
+		}
+	}
+}
+
+func TestDryRunPrintsCommandsWithoutRunningThem(t *testing.T) {
+	recorder := newRecorder(t)
+	dir := t.TempDir()
+	input := touch(t, dir, "input.mp4")
+	second := touch(t, dir, "second.mp4")
+	logo := touch(t, dir, "logo.png")
+	clips := filepath.Join(dir, "clips")
+	os.Mkdir(clips, 0755)
+	touch(t, clips, "a.mp4")
+	touch(t, clips, "b.mp4")
+
+	tests := []struct {
+		name     string
+		args     func(output string) []string
+		output   string
+		commands int
+		want     []string // arguments every command line contains
+	}{
+		{"trim without encoding", func(output string) []string { return []string{"trim", "--no-encode", "--start", "5", "--end", "9", input, output} }, "out.mp4", 1, []string{"-c copy", "-ss 4.00000"}},
+		{"smart trim", func(output string) []string { return []string{"trim", "--smart", "--start", "5", "--end", "9", input, output} }, "out.mp4", 3, []string{"ffmpeg"}},
+		{"concat", func(output string) []string { return []string{"concat", output, input, second} }, "out.mp4", 1, []string{"-f concat", "-c:v copy"}},
+		{"concat dir", func(output string) []string { return []string{"concat-dir", "--encode", clips, output} }, "out.mp4", 1, []string{"-f concat", "-c:v libx264"}},
+		{"skipper", func(output string) []string { return []string{"skipper", "--skip", "30", "--interval", "30", input, output} }, "out.mp4", 3, []string{"ffmpeg"}},
+		{"overlay with logo", func(output string) []string { return []string{"overlay-bg", "--logo", logo, input, output} }, "out.mp4", 1, []string{"-i " + logo}},
+		{"screenshot", func(output string) []string { return []string{"screenshot", "--at", "10", input, output} }, "frame.png", 1, []string{"-frames:v 1"}},
+		{"extract audio", func(output string) []string { return []string{"extract-audio", input, output} }, "audio.aac", 1, []string{"-map 0:a:0", "-c:a copy"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder.Reset()
+			output := filepath.Join(t.TempDir(), test.output)
+			// an earlier output must survive a dry run
+			if err := os.WriteFile(output, []byte("previous"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			code, stdout, stderr := runCLI(append([]string{test.args(output)[0], "--dry-run"}, test.args(output)[1:]...)...)
+			if code != 0 {
+				t.Fatalf("exit code %d\n%s", code, stderr)
+			}
+			for _, call := range recorder.Calls() {
+				if call.Name == "ffmpeg" {
+					t.Errorf("ran ffmpeg %q", call.Args)
+				}
+			}
+			if data, err := os.ReadFile(output); err != nil || string(data) != "previous" {
+				t.Errorf("dry run replaced %s", output)
+			}
+
+			lines := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+				if strings.Contains(line, "ffmpeg ") {
+					lines = append(lines, line)
+				}
+			}
+			if len(lines) != test.commands {
+				t.Fatalf("printed %d ffmpeg commands, want %d:\n%s", len(lines), test.commands, stdout)
+			}
+			if !strings.HasSuffix(lines[len(lines)-1], " "+output) {
+				t.Errorf("last command %q does not write %s", lines[len(lines)-1], output)
+			}
+			for _, line := range lines {
+				for _, want := range test.want {
+					if !strings.Contains(line, want) {
+						t.Errorf("%q does not contain %q", line, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUploadDryRunPrintsSteps(t *testing.T) {
+	newRecorder(t)
+	video := touch(t, t.TempDir(), "video.mp4")
+
+	code, stdout, stderr := runCLI("upload", "facebook-video", "--dry-run", "--page-id", "42", "--token", "secret", "--title", "Part 1", video)
+	if code != 0 {
+		t.Fatalf("exit code %d\n%s", code, stderr)
+	}
+	steps := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(steps) != 3 || !strings.Contains(steps[0], "/42/videos upload_phase=start file_size=5") || !strings.Contains(steps[1], "upload_phase=transfer start_offset=0") || !strings.Contains(steps[2], `title="Part 1"`) {
+		t.Errorf("got steps\n%s\nwant start, transfer and finish", stdout)
+	}
+	if strings.Contains(stdout, "secret") {
+		t.Error("the token was printed")
+	}
+}
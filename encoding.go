@@ -0,0 +1,150 @@
+package animax
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+	EncodeOptions controls how the final stage of a render is encoded.
+	Zero values leave the choice to ffmpeg and the encoder.
+*/
+type EncodeOptions struct {
+	Codec       string // video encoder, e.g. VIDEO_ENCODINGS.Best
+	CRF         int
+	Preset      string
+	Bitrate     string // e.g. "4M"; "0" lets CRF alone drive libvpx-vp9 and libaom-av1
+	MaxRate     string
+	BufSize     string
+	PixelFormat string
+	Profile     string
+	Level       string
+	GOPSize     int
+
+	AudioCodec   string
+	AudioBitrate string // e.g. "128k"
+
+	// TwoPass runs the final stage twice, first to analyse the video and then to hit Bitrate accurately.
+	TwoPass bool
+}
+
+// DefaultEncodeOptions returns sane settings for an entry of VIDEO_ENCODINGS, or just the codec for any other encoder.
+func DefaultEncodeOptions(encoding string) EncodeOptions {
+	switch encoding {
+	case "", VIDEO_ENCODINGS.Best:
+		return EncodeOptions{
+			Codec:        VIDEO_ENCODINGS.Best,
+			CRF:          23,
+			Preset:       "medium",
+			PixelFormat:  "yuv420p",
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		}
+	case VIDEO_ENCODINGS.Efficient:
+		return EncodeOptions{
+			Codec:        VIDEO_ENCODINGS.Efficient,
+			CRF:          31,
+			Bitrate:      "0",
+			PixelFormat:  "yuv420p",
+			AudioCodec:   "libopus",
+			AudioBitrate: "128k",
+		}
+	case VIDEO_ENCODINGS.Compressed:
+		return EncodeOptions{
+			Codec:        VIDEO_ENCODINGS.Compressed,
+			CRF:          30,
+			Bitrate:      "0",
+			PixelFormat:  "yuv420p",
+			AudioCodec:   "libopus",
+			AudioBitrate: "128k",
+		}
+	}
+	return EncodeOptions{Codec: encoding}
+}
+
+// containerCodecs lists the video and audio encoders each output container accepts.
+// Containers missing from the map (e.g. .mkv) accept anything.
+var containerCodecs = map[string][]string{
+	".mp4":  {"libx264", "libx265", "libaom-av1", "mpeg4", "aac", "libmp3lame", "ac3", "libopus"},
+	".mov":  {"libx264", "libx265", "mpeg4", "prores", "aac", "libmp3lame", "ac3", "pcm_s16le"},
+	".webm": {"libvpx", "libvpx-vp9", "libaom-av1", "libopus", "libvorbis"},
+	".avi":  {"libx264", "mpeg4", "aac", "libmp3lame", "ac3", "pcm_s16le"},
+}
+
+func supportsCodec(container string, codec string) bool {
+	codecs, ok := containerCodecs[strings.ToLower(container)]
+	if !ok {
+		return true
+	}
+	for _, c := range codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the codecs can be written to the container picked by the extension of outputPath.
+func (options EncodeOptions) Validate(outputPath string) error {
+	if options.TwoPass && (options.Bitrate == "" || options.Bitrate == "0") {
+		return errors.New("two-pass encoding needs a target bitrate")
+	}
+
+	container := filepath.Ext(outputPath)
+	for _, codec := range []string{options.Codec, options.AudioCodec} {
+		if codec != "" && !supportsCodec(container, codec) {
+			return fmt.Errorf("codec %s cannot be written to a %s container", codec, container)
+		}
+	}
+	return nil
+}
+
+func (options EncodeOptions) args(fileType string) []string {
+	args := []string{}
+	if fileType == video {
+		if options.Codec != "" {args = append(args, "-c:v", options.Codec)}
+		if options.CRF > 0 {args = append(args, "-crf", strconv.Itoa(options.CRF))}
+		if options.Preset != "" {args = append(args, "-preset", options.Preset)}
+		if options.Bitrate != "" {args = append(args, "-b:v", options.Bitrate)}
+		if options.MaxRate != "" {args = append(args, "-maxrate", options.MaxRate)}
+		if options.BufSize != "" {args = append(args, "-bufsize", options.BufSize)}
+		if options.PixelFormat != "" {args = append(args, "-pix_fmt", options.PixelFormat)}
+		if options.Profile != "" {args = append(args, "-profile:v", options.Profile)}
+		if options.Level != "" {args = append(args, "-level", options.Level)}
+		if options.GOPSize > 0 {args = append(args, "-g", strconv.Itoa(options.GOPSize))}
+	}
+	if options.AudioCodec != "" {args = append(args, "-c:a", options.AudioCodec)}
+	if options.AudioBitrate != "" {args = append(args, "-b:a", options.AudioBitrate)}
+	return args
+}
+
+// parseBitrate converts bitrates such as "128k" or "4M" to bits per second.
+func parseBitrate(bitrate string) (int64, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(strings.ToLower(bitrate), "k"):
+		multiplier = 1000
+	case strings.HasSuffix(bitrate, "M"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(bitrate, "G"):
+		multiplier = 1000 * 1000 * 1000
+	}
+	value, err := strconv.ParseFloat(strings.TrimRight(bitrate, "kKMG"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bitrate %q", bitrate)
+	}
+	return int64(value * multiplier), nil
+}
+
+// withPass inserts the two-pass flags in front of the trailing -y of a final stage.
+func withPass(cmd []string, pass int, passLogFile string) []string {
+	withPass := append([]string{}, cmd[:len(cmd)-1]...)
+	withPass = append(withPass, "-pass", strconv.Itoa(pass), "-passlogfile", passLogFile)
+	if pass == 1 {
+		withPass = append(withPass, "-an", "-f", "null")
+	}
+	return append(withPass, cmd[len(cmd)-1])
+}
@@ -0,0 +1,117 @@
+package animax
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Executor runs the external binaries (ffmpeg, ffprobe) used by the package.
+type Executor interface {
+	Run(ctx context.Context, name string, args []string) (stdout []byte, stderr []byte, err error)
+}
+
+// streamer is implemented by executors that can hand stdout to a writer while the command is still running.
+// It is used for progress reporting; executors without it get their stdout replayed once the command exits.
+type streamer interface {
+	Stream(ctx context.Context, name string, args []string, stdout io.Writer) (stderr []byte, err error)
+}
+
+// DefaultExecutor is used by every ffmpeg and ffprobe invocation unless a render is given WithExecutor.
+// Replace it with a RecordingExecutor to run the package without the real binaries.
+var DefaultExecutor Executor = ExecExecutor{}
+
+// ExecExecutor runs commands with os/exec.
+type ExecExecutor struct{}
+
+func (ExecExecutor) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	var stdout bytes.Buffer
+	stderr, err := ExecExecutor{}.Stream(ctx, name, args, &stdout)
+	return stdout.Bytes(), stderr, err
+}
+
+func (ExecExecutor) Stream(ctx context.Context, name string, args []string, stdout io.Writer) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stderr.Bytes(), err
+}
+
+func runStreaming(ctx context.Context, executor Executor, name string, args []string, stdout io.Writer) ([]byte, error) {
+	if s, ok := executor.(streamer); ok {
+		return s.Stream(ctx, name, args, stdout)
+	}
+
+	out, stderr, err := executor.Run(ctx, name, args)
+	stdout.Write(out)
+	return stderr, err
+}
+
+// Command is a single invocation seen by a RecordingExecutor.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// Response is the canned result a RecordingExecutor returns for a command.
+type Response struct {
+	Stdout []byte
+	Stderr []byte
+	Err    error
+}
+
+/*
+	RecordingExecutor records every command instead of running it and answers with canned responses.
+	Handler takes precedence over Responses, which is keyed by binary name ("ffmpeg", "ffprobe").
+	With CreateOutputs set, the last argument of every ffmpeg call is created as an empty file so renders can complete.
+*/
+type RecordingExecutor struct {
+	Responses     map[string]Response
+	Handler       func(cmd Command) Response
+	CreateOutputs bool
+
+	mu    sync.Mutex
+	calls []Command
+}
+
+func (r *RecordingExecutor) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	cmd := Command{Name: name, Args: append([]string{}, args...)}
+	r.mu.Lock()
+	r.calls = append(r.calls, cmd)
+	r.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	response := r.Responses[name]
+	if r.Handler != nil {
+		response = r.Handler(cmd)
+	}
+
+	if r.CreateOutputs && name == "ffmpeg" && response.Err == nil && len(args) > 0 {
+		if output := args[len(args)-1]; output != os.DevNull {
+			os.WriteFile(output, nil, 0644)
+		}
+	}
+	return response.Stdout, response.Stderr, response.Err
+}
+
+// Calls returns the commands recorded so far.
+func (r *RecordingExecutor) Calls() []Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Command{}, r.calls...)
+}
+
+// Reset forgets the recorded commands.
+func (r *RecordingExecutor) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
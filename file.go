@@ -1,13 +1,24 @@
 package animax
 
+import "path/filepath"
+
 type File interface {
 	GetType() string
 	GetFilename() string
 	GetFilePath() string
 	GetExtension() string
+	GetDuration() int64
 }
 
 const (
 	video = "video"
 	audio = "audio"
-)
\ No newline at end of file
+)
+
+// FileType returns "video" or "audio" depending on whether LoadVideo or LoadAudio accepts the extension of path, and "" otherwise.
+func FileType(path string) string {
+	extension := filepath.Ext(path)
+	if contains(extension) {return video}
+	if isAudioExtension(extension) {return audio}
+	return ""
+}
@@ -1,17 +1,94 @@
 package animax
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
-
-	"github.com/google/uuid"
 )
 
 type Args map[string][]subArg
 
+// ErrNoEffects is returned when a render is requested without any effects applied.
+var ErrNoEffects = errors.New("no effects applied")
+
+// RenderError describes the ffmpeg stage that caused a render to fail.
+type RenderError struct {
+	Stage    int
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func newRenderError(stage int, args []string, stderr string, err error) *RenderError {
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return &RenderError{
+		Stage:    stage,
+		Args:     append([]string{}, args...),
+		ExitCode: exitCode,
+		Stderr:   stderr,
+		Err:      err,
+	}
+}
+
+func (e *RenderError) Error() string {
+	reason := e.Err.Error()
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		lines := strings.Split(stderr, "\n")
+		reason = strings.TrimSpace(lines[len(lines)-1])
+	}
+	return fmt.Sprintf("render stage %d failed (exit code %d): %s", e.Stage, e.ExitCode, reason)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+/*
+	effect is one node of a persistent effect chain. Nodes are never modified once created, so every value derived from a
+	file shares the effects applied before it and stays safe to render from several goroutines.
+*/
+type effect struct {
+	flag     string
+	arg      subArg
+	track    *audioTrack    // set for the effects of AddAudioTrack
+	subtitle *subtitleTrack // set for the effects of AddSubtitleTrack
+	previous *effect
+}
+
+func (chain *effect) push(flag string, arg subArg) *effect {
+	return &effect{flag: flag, arg: arg, previous: chain}
+}
+
+// list returns the effects of the chain in the order they were applied.
+func (chain *effect) list() []*effect {
+	effects := []*effect{}
+	for node := chain; node != nil; node = node.previous {
+		effects = append(effects, node)
+	}
+	for i, j := 0, len(effects)-1; i < j; i, j = i+1, j-1 {
+		effects[i], effects[j] = effects[j], effects[i]
+	}
+	return effects
+}
+
+// args materializes the chain, oldest effect first. Every call returns a new map.
+func (chain *effect) args() Args {
+	args := make(Args)
+	for _, node := range chain.list() {
+		args.addArg(node.flag, node.arg)
+	}
+	return args
+}
+
 func secondsToHMS(seconds int) string {
 	hours := seconds / 3600
 	minutes := (seconds % 3600) / 60
@@ -20,14 +97,21 @@ func secondsToHMS(seconds int) string {
 	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
 }
 
+// fixSpace splits the arguments holding several words, except input paths and filter graphs.
 func fixSpace(slice *[]string) {
 	for i := 0; i < len(*slice); i++ {
+		if i > 0 && keepsSpaces((*slice)[i-1]) {
+			continue
+		}
 		splits := strings.Fields((*slice)[i])
 		if len(splits) > 1 {
 			*slice = append((*slice)[:i], append(splits[:], (*slice)[i+1:]...)...)
 		}
 	}
 	for i := 0; i < len(*slice); i++ {
+		if i > 0 && keepsSpaces((*slice)[i-1]) {
+			continue
+		}
 		if len(strings.Fields((*slice)[i])) > 1 {
 			fixSpace(slice)
 			return
@@ -35,54 +119,8 @@ func fixSpace(slice *[]string) {
 	}
 }
 
-func isTrim(cmd *[]string) bool {
-	for _, v := range *cmd {
-		if strings.Contains(v, "-ss") {
-			return true
-		}
-	}
-	return false
-}
-
-func shouldEncode(cmd *[]string, currentIndex int, renderStages *[][]string) {
-	if currentIndex == len(*renderStages)-1 {
-		*cmd = append(*cmd, []string{"-c:v", "libx264", "-y"}...)
-		return
-	}
-
-	next := (*renderStages)[currentIndex+1]
-
-	if isTrim(&next) {
-		// fmt.Println("Next is trim")
-		fixTrim(cmd)
-		*cmd = append(*cmd, []string{"-c", "copy", "-y"}...)
-		fmt.Printf("After %+v\n", cmd)
-		return
-	}
-
-	*cmd = append(*cmd, []string{"-c", "copy", "-y"}...)
-	// *cmd = append(*cmd, []string{"-c:v", "libx264"}...)
-}
-
-func fixTrim(cmd *[]string) {
-	input := (*cmd)[1:3]
-	temp := make([]string, len(input))
-	copy(temp, input)
-	start := (*cmd)[3:5]
-	copy((*cmd)[1:3], start)
-	copy((*cmd)[3:5], temp)
-	*cmd = (*cmd)[:7]
-
-	startTime, err := strconv.ParseFloat((*cmd)[2], 64)
-	if err != nil {
-		return
-	}
-	endTime, err := strconv.ParseFloat((*cmd)[6], 64)
-	if err != nil {
-		return
-	}
-
-	(*cmd)[6] = fmt.Sprintf("%.5f", endTime-startTime)
+func keepsSpaces(flag string) bool {
+	return flag == "-i" || flag == "-filter_complex"
 }
 
 func removeFiles(files []string) {
@@ -98,52 +136,96 @@ func removeIfExists(path string) {
 	}
 }
 
-func startRender(renderStages *[][]string, file File, finalOutputPath string) {
-	base := []string{"ffmpeg", "-i"}
-
-	workingDir := uuid.New().String()
-	os.Mkdir(workingDir, os.ModePerm)
-	defer os.RemoveAll(workingDir)
-	temp := uuid.New().String()
-
-	inputPath := file.GetFilePath()
-	nextPath := fmt.Sprintf("%s/%s%s", workingDir, temp, file.GetExtension())
+func startRender(ctx context.Context, plan RenderPlan, settings renderSettings) error {
+	if len(plan.Stages) == 0 {
+		return ErrNoEffects
+	}
+	// only a plan that can run replaces the existing output
+	removeIfExists(plan.OutputPath)
+
+	os.MkdirAll(plan.WorkingDir, os.ModePerm)
+	first := 0
+	if settings.store != nil {
+		first = resumeStage(plan, settings)
+	} else {
+		defer os.RemoveAll(plan.WorkingDir)
+	}
 
-	for i := 0; i < len(*renderStages); i++ {
-		if len((*renderStages)[i]) == 0 {
-			continue
+	for i := first; i < len(plan.Stages); i++ {
+		stage := plan.Stages[i]
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		cmd := base
-		cmd = append(cmd, inputPath)
-
-		fixSpace(&(*renderStages)[i])
-		cmd = append(cmd, (*renderStages)[i]...)
-
-		if isTrim(&cmd) {
-			// fixTrim(&cmd)
-			shouldEncode(&cmd, i, renderStages)
+		cmd := stage.Args
+		Logger.Infoln("Command to be executed: " + strings.Join(cmd, " "))
+		var stderr []byte
+		var err error
+		if settings.progress != nil {
+			stderr, err = runStreaming(ctx, settings.executor, cmd[0], cmd[1:], newProgressWriter(settings.progress, i, len(plan.Stages), stage.duration))
 		} else {
-			switch file.GetType() {
-			case video:
-				cmd = append(cmd, []string{"-c:v", "libx264", "-y"}...)
-			case audio:
-				cmd = append(cmd, []string{"-y"}...)
+			_, stderr, err = settings.executor.Run(ctx, cmd[0], cmd[1:])
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				Logger.Warnf("Render stage %d cancelled", i)
+				return ctx.Err()
 			}
+			Logger.Errorf("Render stage %d failed | Error: %s", i, string(stderr))
+			return newRenderError(i, cmd, string(stderr), err)
 		}
-		cmd = append(cmd, nextPath)
-
-		execute := exec.Command(cmd[0], cmd[1:]...)
 
-		Logger.Infoln("Command to be executed: " + execute.String())
-		output, err := execute.CombinedOutput()
-		if err != nil {
-			fmt.Println(string(output))
+		if settings.store != nil {
+			completed := i + 1
+			if err := settings.store.Update(settings.jobID, func(record *JobRecord) { record.CompletedStages = completed }); err != nil {
+				Logger.Warnf("Unable to record stage %d of job %s | Error: %s", i, settings.jobID, err)
+			}
 		}
-		inputPath = nextPath
-		nextPath = fmt.Sprintf("%s/%s.mp4", workingDir, uuid.New().String())
 	}
 
-	os.Rename(inputPath, finalOutputPath)
+	if err := os.Rename(plan.Stages[len(plan.Stages)-1].Output, plan.OutputPath); err != nil {
+		return err
+	}
+	if settings.store != nil {
+		os.RemoveAll(plan.WorkingDir)
+		settings.store.Update(settings.jobID, func(record *JobRecord) { record.WorkingDir, record.PlanHash = "", "" })
+	}
+	return nil
+}
 
-}
\ No newline at end of file
+/*
+	resumeStage returns the first stage left to run for the job of settings. Stages completed by a previous attempt are skipped
+	when they belong to the same plan and their output is still there; otherwise the render starts over.
+*/
+func resumeStage(plan RenderPlan, settings renderSettings) int {
+	hash := plan.hash()
+	first := 0
+	err := settings.store.Update(settings.jobID, func(record *JobRecord) {
+		if record.PlanHash == hash && record.WorkingDir == plan.WorkingDir {
+			first = record.CompletedStages
+		}
+		for first > 0 && first <= len(plan.Stages) && !stageOutputExists(plan.Stages[first-1]) {
+			first--
+		}
+		if first > len(plan.Stages) {
+			first = 0
+		}
+		record.WorkingDir, record.PlanHash, record.CompletedStages = plan.WorkingDir, hash, first
+	})
+	if err != nil {
+		Logger.Warnf("Unable to load job %s, rendering from the start | Error: %s", settings.jobID, err)
+		return 0
+	}
+	if first > 0 {
+		Logger.Infof("Resuming job %s at stage %d/%d", settings.jobID, first+1, len(plan.Stages))
+	}
+	return first
+}
+
+func stageOutputExists(stage PlanStage) bool {
+	if stage.Output == os.DevNull {
+		return true
+	}
+	_, err := os.Stat(stage.Output)
+	return err == nil
+}
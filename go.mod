@@ -6,6 +6,7 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,121 @@
+package animax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyframeIndex caches the keyframe positions of a video. Copies of a Video share it.
+type keyframeIndex struct {
+	mu     sync.Mutex
+	probed bool
+	times  []time.Duration
+	err    error
+}
+
+func probeKeyframes(ctx context.Context, videoPath string) ([]time.Duration, error) {
+	stdout, stderr, err := DefaultExecutor.Run(ctx, "ffprobe", []string{"-v", "error", "-select_streams", "v:0", "-show_entries", "packet=pts_time,flags", "-of", "csv=print_section=0", videoPath})
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %s: %w: %s", videoPath, err, strings.TrimSpace(string(stderr)))
+	}
+
+	keyframes := []time.Duration{}
+	for _, line := range strings.Split(string(stdout), "\n") {
+		ptsTime, flags, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(ptsTime, 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, time.Duration(seconds*float64(time.Second)))
+	}
+
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("videoPath: %s | no keyframes found", videoPath)
+	}
+	sort.Slice(keyframes, func(i, j int) bool { return keyframes[i] < keyframes[j] })
+	return keyframes, nil
+}
+
+/*
+	Keyframes returns the positions of the keyframes of the first video stream, in order.
+	They are read from the packet flags once and cached for every copy of the loaded video.
+*/
+func (video Video) Keyframes() ([]time.Duration, error) {
+	return video.KeyframesContext(context.Background())
+}
+
+// Same as Keyframes but stops probing as soon as ctx is cancelled. A cancelled probe is not cached.
+func (video Video) KeyframesContext(ctx context.Context) ([]time.Duration, error) {
+	if video.keyframes == nil {
+		return probeKeyframes(ctx, video.FilePath)
+	}
+
+	video.keyframes.mu.Lock()
+	defer video.keyframes.mu.Unlock()
+	if !video.keyframes.probed {
+		times, err := probeKeyframes(ctx, video.FilePath)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		video.keyframes.times, video.keyframes.err, video.keyframes.probed = times, err, true
+	}
+	return video.keyframes.times, video.keyframes.err
+}
+
+// KeyframeBefore returns the last keyframe at or before position, which is where a stream copy starting at position really starts.
+func (video Video) KeyframeBefore(position time.Duration) (time.Duration, error) {
+	return video.KeyframeBeforeContext(context.Background(), position)
+}
+
+func (video Video) KeyframeBeforeContext(ctx context.Context, position time.Duration) (time.Duration, error) {
+	keyframes, err := video.KeyframesContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	index := sort.Search(len(keyframes), func(i int) bool { return keyframes[i] > position })
+	if index == 0 {
+		return keyframes[0], nil
+	}
+	return keyframes[index-1], nil
+}
+
+// KeyframeAfter returns the first keyframe at or after position.
+func (video Video) KeyframeAfter(position time.Duration) (time.Duration, error) {
+	return video.KeyframeAfterContext(context.Background(), position)
+}
+
+func (video Video) KeyframeAfterContext(ctx context.Context, position time.Duration) (time.Duration, error) {
+	keyframes, err := video.KeyframesContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	index := sort.Search(len(keyframes), func(i int) bool { return keyframes[i] >= position })
+	if index == len(keyframes) {
+		return 0, errors.New("no keyframe after position")
+	}
+	return keyframes[index], nil
+}
+
+// NearestKeyframe returns the keyframe closest to position.
+func (video Video) NearestKeyframe(position time.Duration) (time.Duration, error) {
+	before, err := video.KeyframeBefore(position)
+	if err != nil {
+		return 0, err
+	}
+	after, err := video.KeyframeAfter(position)
+	if err != nil || position-before <= after-position {
+		return before, nil
+	}
+	return after, nil
+}
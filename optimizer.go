@@ -0,0 +1,224 @@
+package animax
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// trimWindow is the part of the input that survives every trim applied to a file.
+type trimWindow struct {
+	start float64
+	end   float64
+}
+
+func parseTrim(value string) (start float64, end float64, err error) {
+	parts := strings.Fields(value)
+	if len(parts) != 3 || parts[1] != "-to" {
+		return 0, 0, fmt.Errorf("invalid trim %q", value)
+	}
+	start, err = strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+/*
+	Trims are relative to the output of the previous trim, so trim(10, 60) followed by trim(5, 20)
+	keeps 15s to 30s of the input. Composing them lets every trim run in a single seek.
+*/
+func composeTrims(trims []subArg) (window trimWindow, ok bool) {
+	window = trimWindow{start: 0, end: math.Inf(1)}
+	for _, trim := range trims {
+		start, end, err := parseTrim(trim.Value)
+		if err != nil {
+			Logger.Warnf("Skipping trim: %s", err)
+			continue
+		}
+		window.end = math.Min(window.end, window.start+end)
+		window.start += start
+		ok = true
+	}
+	if window.end < window.start {
+		window.end = window.start
+	}
+	return window, ok
+}
+
+// tempoFilters change the timestamps of the frames they pass, a trim after one of them cuts a different part of the input.
+var tempoFilters = []string{"setpts", "asetpts", "atempo", "asetrate"}
+
+// changesTempo reports whether a filter chain contains a filter of tempoFilters.
+func changesTempo(chain string) bool {
+	for _, filter := range strings.Split(chain, ",") {
+		name := strings.TrimSpace(strings.SplitN(filter, "=", 2)[0])
+		for _, tempo := range tempoFilters {
+			if name == tempo {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seekTrims returns the trims chained before the first tempo change, the only ones a seek can replace.
+func seekTrims(effects []*effect, file File) (trims []subArg, count int) {
+	for _, effect := range effects {
+		if effect.flag == "-ss" {
+			trims = append(trims, effect.arg)
+			continue
+		}
+		if filterStream(effect.flag, file) != "" && changesTempo(effect.arg.Value) {
+			break
+		}
+	}
+	return trims, len(trims)
+}
+
+// filter returns the trim filter cutting the window out of a stream, "v" or "a", with its timestamps starting at 0.
+func (window trimWindow) filter(stream string) string {
+	trim := fmt.Sprintf("trim=start=%f", window.start)
+	if !math.IsInf(window.end, 1) {
+		trim += fmt.Sprintf(":end=%f", window.end)
+	}
+	if stream == "a" {
+		return "a" + trim + ",asetpts=PTS-STARTPTS"
+	}
+	return trim + ",setpts=PTS-STARTPTS"
+}
+
+// filterStream tells which stream a filter flag applies to: "v", "a", or "" if the flag is not a filter.
+func filterStream(flag string, file File) string {
+	switch flag {
+	case "-filter_complex", "-vf", "-filter:v":
+		if file.GetType() == audio {
+			return "a"
+		}
+		return "v"
+	case "-af", "-filter:a":
+		return "a"
+	}
+	return ""
+}
+
+/*
+	collapseStages merges every effect applied to a file into a single ffmpeg pass. Trims are composed into one input seek,
+	video and audio filters are chained into one -filter_complex graph with a labeled chain per stream,
+	and the remaining flags are passed through as output options. Filters run in the order they were chained,
+	repeated ones included. Trims chained after a filter changing the tempo, e.g. Nightcore, cut the retimed stream and
+	become trim filters at their place in the chain instead.
+*/
+func collapseStages(effects []*effect, file File) [][]string {
+	videoFilters := []string{}
+	outputOptions := []string{}
+
+	trims, seeks := seekTrims(effects, file)
+	window, trimmed := composeTrims(trims)
+	length := fileLength(file)
+	if trimmed && (length == 0 || window.end < length) {
+		length = window.end
+	}
+	length = math.Max(length-window.start, 0)
+	seek := []string{}
+	if trimmed {
+		seek = append(seek, "-ss", fmt.Sprintf("%f", window.start), "-to", fmt.Sprintf("%f", window.end))
+	}
+	for _, effect := range effects {
+		// the length of a retimed output is not known
+		if filterStream(effect.flag, file) != "" && changesTempo(effect.arg.Value) {
+			length = 0
+		}
+	}
+
+	source := audioSource(file)
+	audio := newAudioGraph(source)
+	subtitleMaps := []string{}
+	subtitles := 0
+	for _, effect := range effects {
+		switch {
+		case effect.flag == "-ss" && seeks > 0:
+			seeks--
+			continue
+		case effect.flag == "-ss":
+			cut, ok := composeTrims([]subArg{effect.arg})
+			if !ok {continue}
+			if file.GetType() == video {
+				videoFilters = append(videoFilters, cut.filter("v"))
+			}
+			if hasAudio(file) {
+				audio.filters = append(audio.filters, cut.filter("a"))
+			}
+			continue
+		case effect.track != nil:
+			audio.mix(effect.track, length, hasAudio(file))
+			continue
+		case effect.subtitle != nil:
+			// subtitle files are timed to the source, they are seeked like it
+			subtitleMaps = append(subtitleMaps, "-map", fmt.Sprintf("%d:s", audio.addInput(effect.subtitle.path, seek...)))
+			if effect.subtitle.language != "" {
+				subtitleMaps = append(subtitleMaps, fmt.Sprintf("-metadata:s:s:%d", subtitles), "language="+effect.subtitle.language)
+			}
+			subtitles++
+			continue
+		}
+
+		switch filterStream(effect.flag, file) {
+		case "v":
+			if effect.arg.Key == "subtitles" && window.start > 0 {
+				// burned subtitles are timed to the source, the filter sees its timestamps and the output gets them back
+				videoFilters = append(videoFilters, fmt.Sprintf("setpts=PTS+%f/TB", window.start), effect.arg.Value, fmt.Sprintf("setpts=PTS-%f/TB", window.start))
+				continue
+			}
+			videoFilters = append(videoFilters, effect.arg.Value)
+		case "a":
+			audio.filters = append(audio.filters, effect.arg.Value)
+		default:
+			outputOptions = append(outputOptions, effect.flag, effect.arg.Value)
+		}
+	}
+
+	// the seek comes first, buildRenderPlan puts it before the input
+	stage := append([]string{}, seek...)
+	stage = append(stage, audio.inputs...)
+
+	graph := []string{}
+	maps := []string{}
+	audioChains, filtered := audio.finish()
+	if len(videoFilters) > 0 {
+		graph = append(graph, fmt.Sprintf("[0:v]%s[v]", strings.Join(videoFilters, ",")))
+		maps = append(maps, "-map", "[v]")
+	} else if file.GetType() == video && (filtered || subtitles > 0) {
+		maps = append(maps, "-map", "0:v")
+	}
+	if filtered {
+		graph = append(graph, audioChains...)
+		maps = append(maps, "-map", "[a]")
+	} else if len(videoFilters) > 0 || subtitles > 0 {
+		maps = append(maps, "-map", source+"?")
+	}
+
+	if len(graph) > 0 {
+		stage = append(stage, "-filter_complex", strings.Join(graph, ";"))
+	}
+	if len(graph) > 0 || subtitles > 0 {
+		stage = append(stage, maps...)
+	} else if source != "0:a" {
+		// selecting an audio track is enough to render
+		stage = append(stage, "-map", "0:v", "-map", source)
+	}
+	// the subtitle codec depends on the output container and is added with it, see Video.plan
+	stage = append(stage, subtitleMaps...)
+	stage = append(stage, audio.options...)
+	stage = append(stage, outputOptions...)
+
+	if len(stage) == 0 {
+		return [][]string{}
+	}
+	return [][]string{stage}
+}
@@ -0,0 +1,159 @@
+package animax
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PlanStage is a single ffmpeg invocation of a render.
+type PlanStage struct {
+	Args       []string
+	Input      string
+	Output     string
+	StreamCopy bool
+
+	// expected duration of the stage output in seconds, used for progress reporting
+	duration float64
+}
+
+// RenderPlan lists the ffmpeg invocations a render will run, in order.
+// Every stage reads InputPath; the output of the last one is moved to OutputPath.
+type RenderPlan struct {
+	InputPath  string
+	OutputPath string
+	WorkingDir string
+	Stages     []PlanStage
+}
+
+/*
+	buildRenderPlan turns the stage collapseStages made of the effects into ffmpeg invocations. Every effect is applied in
+	that one pass, so the plan has a single stage, preceded by the analysis pass of two-pass encodes.
+*/
+func buildRenderPlan(stage []string, file File, outputPath string, settings renderSettings) RenderPlan {
+	plan := RenderPlan{
+		InputPath:  file.GetFilePath(),
+		OutputPath: outputPath,
+		WorkingDir: settings.workingDir(),
+	}
+
+	fixSpace(&stage)
+	seek, options := inputSeek(stage)
+	cmd := append([]string{"ffmpeg"}, seek...)
+	cmd = append(cmd, "-i", plan.InputPath)
+	cmd = append(cmd, options...)
+	if settings.encode != nil {
+		cmd = append(cmd, settings.encode.args(file.GetType())...)
+	}
+	cmd = append(cmd, "-y")
+	duration := stageDuration(stage, float64(file.GetDuration()))
+
+	if settings.encode != nil && settings.encode.TwoPass && file.GetType() == video {
+		passLogFile := fmt.Sprintf("%s/passlog", plan.WorkingDir)
+		firstPass := withPass(cmd, 1, passLogFile)
+		if settings.progress != nil {
+			firstPass = append(firstPass, []string{"-progress", "pipe:1", "-nostats"}...)
+		}
+		plan.Stages = append(plan.Stages, PlanStage{
+			Args:     append(firstPass, os.DevNull),
+			Input:    plan.InputPath,
+			Output:   os.DevNull,
+			duration: duration,
+		})
+		cmd = withPass(cmd, 2, passLogFile)
+	}
+	if settings.progress != nil {
+		cmd = append(cmd, []string{"-progress", "pipe:1", "-nostats"}...)
+	}
+	// ffmpeg picks the container from the extension, the stage is written in the one of the output
+	output := stagePath(plan.WorkingDir, len(plan.Stages), outputExtension(outputPath, file))
+	cmd = append(cmd, output)
+
+	plan.Stages = append(plan.Stages, PlanStage{
+		Args:       cmd,
+		Input:      plan.InputPath,
+		Output:     output,
+		StreamCopy: isStreamCopy(cmd),
+		duration:   duration,
+	})
+	return plan
+}
+
+// inputSeek splits the seek collapseStages puts first in a stage from the output options, it goes before the input.
+func inputSeek(stage []string) (seek []string, options []string) {
+	i := 0
+	for _, flag := range []string{"-ss", "-to"} {
+		if i < len(stage)-1 && stage[i] == flag {i += 2}
+	}
+	return stage[:i], stage[i:]
+}
+
+// stagePath names stage outputs by position so the same effects always plan the same commands.
+func stagePath(workingDir string, stage int, extension string) string {
+	return fmt.Sprintf("%s/stage-%d%s", workingDir, stage, extension)
+}
+
+// outputExtension is the extension of outputPath, or the one of file when outputPath has none.
+func outputExtension(outputPath string, file File) string {
+	if extension := filepath.Ext(outputPath); extension != "" {
+		return extension
+	}
+	return file.GetExtension()
+}
+
+func isStreamCopy(cmd []string) bool {
+	for i := 0; i < len(cmd)-1; i++ {
+		if cmd[i] == "-c" && cmd[i+1] == "copy" {
+			return true
+		}
+	}
+	return false
+}
+
+func (stage PlanStage) mode() string {
+	if stage.StreamCopy {
+		return "copy"
+	}
+	return "encode"
+}
+
+// options returns the arguments of the stage without the binary, the input and the output.
+func (stage PlanStage) options() []string {
+	options := []string{}
+	for i := 1; i < len(stage.Args)-1; i++ {
+		if stage.Args[i] == "-i" && stage.Args[i+1] == stage.Input {
+			i++
+			continue
+		}
+		options = append(options, stage.Args[i])
+	}
+	return options
+}
+
+func (plan RenderPlan) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s -> %s (%d stages, working dir %s)\n", plan.InputPath, plan.OutputPath, len(plan.Stages), plan.WorkingDir)
+	for i, stage := range plan.Stages {
+		fmt.Fprintf(&builder, "  [%d] %-6s %s\n", i, stage.mode(), strings.Join(stage.Args, " "))
+	}
+	return builder.String()
+}
+
+// DOT renders the plan as a Graphviz digraph, one node per stage.
+func (plan RenderPlan) DOT() string {
+	var builder strings.Builder
+	builder.WriteString("digraph render {\n\trankdir=LR;\n")
+	fmt.Fprintf(&builder, "\tinput [shape=box, label=%q];\n", plan.InputPath)
+	for i, stage := range plan.Stages {
+		fmt.Fprintf(&builder, "\tstage%d [label=%q];\n", i, fmt.Sprintf("%d: %s\n%s", i, stage.mode(), strings.Join(stage.options(), " ")))
+	}
+	fmt.Fprintf(&builder, "\toutput [shape=box, label=%q];\n", plan.OutputPath)
+
+	builder.WriteString("\tinput")
+	for i := range plan.Stages {
+		fmt.Fprintf(&builder, " -> stage%d", i)
+	}
+	builder.WriteString(" -> output;\n}\n")
+	return builder.String()
+}
@@ -0,0 +1,307 @@
+package animax_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pichan321/animax"
+)
+
+const (
+	videoProbe = `{"streams": [{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "r_frame_rate": "30/1", "avg_frame_rate": "30/1", "nb_frames": "3600"}, {"index": 1, "codec_type": "audio", "codec_name": "aac"}], "format": {"duration": "120.0"}}`
+	audioProbe = `{"streams": [{"index": 0, "codec_type": "audio", "codec_name": "mp3", "sample_rate": "44100", "channels": 2}], "format": {"duration": "180.0"}}`
+)
+
+// newRecorder answers ffprobe with a 2 minute 1080p video, or a 3 minute song for .mp3 files, and records ffmpeg.
+func newRecorder() *animax.RecordingExecutor {
+	return &animax.RecordingExecutor{
+		CreateOutputs: true,
+		Handler: func(cmd animax.Command) animax.Response {
+			if cmd.Name != "ffprobe" {
+				return animax.Response{}
+			}
+			if strings.HasSuffix(cmd.Args[len(cmd.Args)-1], ".mp3") {
+				return animax.Response{Stdout: []byte(audioProbe)}
+			}
+			return animax.Response{Stdout: []byte(videoProbe)}
+		},
+	}
+}
+
+func touch(t *testing.T, dir string, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlanArgs(t *testing.T) {
+	recorder := newRecorder()
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+	defer func() { animax.DefaultExecutor = executor }()
+
+	dir := t.TempDir()
+	video, err := animax.LoadVideo(touch(t, dir, "input.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	music, err := animax.LoadAudio(touch(t, dir, "music.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtitles := touch(t, dir, "captions.srt")
+
+	// {input} and {dir} stand for the input path and the working dir of the plan
+	tests := []struct {
+		name    string
+		clip    *animax.Video
+		options []animax.RenderOption
+		want    [][]string
+	}{
+		{
+			name: "trim, blur and volume",
+			clip: video.TrimRange(10*time.Second, 20*time.Second).Blur(5).ChangeVolume(0.5),
+			want: [][]string{{
+				"ffmpeg", "-ss", "10.000000", "-to", "20.000000", "-i", "{input}",
+				"-filter_complex", "[0:v]boxblur=5[v];[0:a]volume=0.500000[a]", "-map", "[v]", "-map", "[a]",
+				"-c:v", "libx264", "-crf", "23", "-preset", "medium", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "128k",
+				"-y", "{dir}/stage-0.mp4",
+			}},
+		},
+		{
+			name:    "two-pass",
+			clip:    video.Blur(3),
+			options: []animax.RenderOption{animax.WithEncodeOptions(animax.EncodeOptions{Codec: "libx264", Bitrate: "2M", TwoPass: true})},
+			want: [][]string{
+				{
+					"ffmpeg", "-i", "{input}", "-filter_complex", "[0:v]boxblur=3[v]", "-map", "[v]", "-map", "0:a?",
+					"-c:v", "libx264", "-b:v", "2M", "-pass", "1", "-passlogfile", "{dir}/passlog", "-an", "-f", "null", "-y", os.DevNull,
+				},
+				{
+					"ffmpeg", "-i", "{input}", "-filter_complex", "[0:v]boxblur=3[v]", "-map", "[v]", "-map", "0:a?",
+					"-c:v", "libx264", "-b:v", "2M", "-pass", "2", "-passlogfile", "{dir}/passlog", "-y", "{dir}/stage-1.mp4",
+				},
+			},
+		},
+		{
+			name: "audio track",
+			clip: video.TrimRange(10*time.Second, 40*time.Second).AddAudioTrack(music, animax.AudioTrackOptions{Volume: 0.3, FadeOut: 2 * time.Second}),
+			want: [][]string{{
+				"ffmpeg", "-ss", "10.000000", "-to", "40.000000", "-i", "{input}", "-i", music.FilePath,
+				"-filter_complex", "[1:a]volume=0.300000,afade=t=out:st=28.000000:d=2.000000[a1];[0:a][a1]amix=inputs=2:duration=first:dropout_transition=0:normalize=0[a]",
+				"-map", "0:v", "-map", "[a]",
+				"-c:v", "libx264", "-crf", "23", "-preset", "medium", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "128k",
+				"-y", "{dir}/stage-0.mp4",
+			}},
+		},
+		{
+			name: "replacing audio track",
+			clip: video.TrimRange(10*time.Second, 40*time.Second).AddAudioTrack(music, animax.AudioTrackOptions{Replace: true}),
+			want: [][]string{{
+				"ffmpeg", "-ss", "10.000000", "-to", "40.000000", "-i", "{input}", "-i", music.FilePath,
+				"-filter_complex", "[1:a]apad=whole_dur=30.000000,atrim=end=30.000000[a]", "-map", "0:v", "-map", "[a]",
+				"-c:v", "libx264", "-crf", "23", "-preset", "medium", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "128k",
+				"-y", "{dir}/stage-0.mp4",
+			}},
+		},
+		{
+			name: "subtitle track",
+			clip: video.TrimRange(10*time.Second, 40*time.Second).AddSubtitleTrack(subtitles, "eng"),
+			want: [][]string{{
+				"ffmpeg", "-ss", "10.000000", "-to", "40.000000", "-i", "{input}", "-ss", "10.000000", "-to", "40.000000", "-i", subtitles,
+				"-map", "0:v", "-map", "0:a?", "-map", "1:s", "-metadata:s:s:0", "language=eng", "-c:s", "mov_text",
+				"-c:v", "libx264", "-crf", "23", "-preset", "medium", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "128k",
+				"-y", "{dir}/stage-0.mp4",
+			}},
+		},
+		{
+			name: "burned subtitles",
+			clip: video.TrimRange(10*time.Second, 40*time.Second).BurnSubtitles(subtitles, animax.SubtitleStyle{}),
+			want: [][]string{{
+				"ffmpeg", "-ss", "10.000000", "-to", "40.000000", "-i", "{input}",
+				"-filter_complex", "[0:v]setpts=PTS+10.000000/TB,subtitles=filename=" + subtitles + ",setpts=PTS-10.000000/TB[v]",
+				"-map", "[v]", "-map", "0:a?",
+				"-c:v", "libx264", "-crf", "23", "-preset", "medium", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "128k",
+				"-y", "{dir}/stage-0.mp4",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output.mp4")
+			plan, err := test.clip.Plan(output, "", test.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replacer := strings.NewReplacer("{input}", video.FilePath, "{dir}", plan.WorkingDir)
+			want := [][]string{}
+			for _, stage := range test.want {
+				args := []string{}
+				for _, arg := range stage {
+					args = append(args, replacer.Replace(arg))
+				}
+				want = append(want, args)
+			}
+
+			got := [][]string{}
+			for _, stage := range plan.Stages {
+				got = append(got, stage.Args)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("plan\n got %q\nwant %q", got, want)
+			}
+
+			// rendering runs exactly the planned commands
+			recorder.Reset()
+			if _, err := test.clip.RenderContext(context.Background(), output, "", append(test.options, animax.WithExecutor(recorder))...); err != nil {
+				t.Fatal(err)
+			}
+			ran := [][]string{}
+			for _, call := range recorder.Calls() {
+				if call.Name == "ffmpeg" {
+					ran = append(ran, append([]string{call.Name}, call.Args...))
+				}
+			}
+			if len(ran) != len(plan.Stages) {
+				t.Fatalf("render ran %d ffmpeg commands, want %d", len(ran), len(plan.Stages))
+			}
+			// every render gets its own working dir
+			last := ran[len(ran)-1]
+			replacer = strings.NewReplacer(filepath.Dir(last[len(last)-1]), plan.WorkingDir)
+			for i, args := range ran {
+				for j := range args {
+					args[j] = replacer.Replace(args[j])
+				}
+				if !reflect.DeepEqual(args, got[i]) {
+					t.Errorf("render ran\n%q\nwant\n%q", args, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAudioPlanKeepsTrimsAfterTempoChanges(t *testing.T) {
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = newRecorder()
+	defer func() { animax.DefaultExecutor = executor }()
+
+	dir := t.TempDir()
+	song, err := animax.LoadAudio(touch(t, dir, "song.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		audio *animax.Audio
+		want  []string
+	}{
+		{
+			name:  "trim then nightcore",
+			audio: song.TrimRange(10*time.Second, 20*time.Second).Nightcore(),
+			want:  []string{"ffmpeg", "-ss", "10.000000", "-to", "20.000000", "-i", song.FilePath, "-filter_complex", "[0:a]asetrate=44100*1.25,atempo=1.25[a]", "-map", "[a]"},
+		},
+		{
+			name:  "nightcore then trim",
+			audio: song.Nightcore().TrimRange(10*time.Second, 20*time.Second),
+			want:  []string{"ffmpeg", "-i", song.FilePath, "-filter_complex", "[0:a]asetrate=44100*1.25,atempo=1.25,atrim=start=10.000000:end=20.000000,asetpts=PTS-STARTPTS[a]", "-map", "[a]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := test.audio.Plan(filepath.Join(dir, "output.mp3"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := plan.Stages[0].Args
+			// the output options follow
+			if len(args) < len(test.want) || !reflect.DeepEqual(args[:len(test.want)], test.want) {
+				t.Errorf("plan\n got %q\nwant %q", args, test.want)
+			}
+		})
+	}
+}
+
+func TestRejectedRenderKeepsTheOutput(t *testing.T) {
+	recorder := newRecorder()
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+	defer func() { animax.DefaultExecutor = executor }()
+
+	dir := t.TempDir()
+	video, err := animax.LoadVideo(touch(t, dir, "input.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := animax.LoadAudio(touch(t, dir, "song.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renders := map[string]func(output string) error{
+		"no effects": func(output string) error {
+			_, err := video.RenderContext(context.Background(), output, "", animax.WithExecutor(recorder))
+			return err
+		},
+		"invalid encode options": func(output string) error {
+			_, err := video.Blur(2).RenderContext(context.Background(), output, "", animax.WithExecutor(recorder), animax.WithEncodeOptions(animax.EncodeOptions{Codec: "libx264", TwoPass: true}))
+			return err
+		},
+		"invalid audio encode options": func(output string) error {
+			_, err := song.Nightcore().RenderContext(context.Background(), strings.TrimSuffix(output, ".mp4")+".mp3", animax.WithExecutor(recorder), animax.WithEncodeOptions(animax.EncodeOptions{TwoPass: true}))
+			return err
+		},
+	}
+	for name, render := range renders {
+		t.Run(name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output.mp4")
+			for _, path := range []string{output, strings.TrimSuffix(output, ".mp4") + ".mp3"} {
+				if err := os.WriteFile(path, []byte("previous render"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := render(output); err == nil {
+				t.Fatal("render succeeded, want an error")
+			}
+			for _, path := range []string{output, strings.TrimSuffix(output, ".mp4") + ".mp3"} {
+				if data, err := os.ReadFile(path); err != nil || string(data) != "previous render" {
+					t.Errorf("%s was removed or changed by a render that did not run", path)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterAudioExtensionWhileLoading(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		extension := fmt.Sprintf(".test%d", i)
+		go func() {
+			defer wg.Done()
+			animax.RegisterAudioExtension(extension)
+		}()
+		go func() {
+			defer wg.Done()
+			animax.FileType("song.mp3")
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 8; i++ {
+		if file := fmt.Sprintf("song.TEST%d", i); animax.FileType(file) != "audio" {
+			t.Errorf("%s is not loaded as audio", file)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package animax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaInfo is everything ffprobe reports about a media file.
+type MediaInfo struct {
+	Path           string
+	FormatName     string
+	FormatLongName string
+	Duration       time.Duration
+	StartTime      time.Duration
+	Size           int64
+	Bitrate        int64
+	Tags           map[string]string
+	Streams        []StreamInfo
+	Chapters       []Chapter
+}
+
+// StreamInfo describes a single stream of a media file. Fields that do not apply to the stream type are left empty.
+type StreamInfo struct {
+	Index         int
+	Type          string // video, audio, subtitle, data or attachment
+	Codec         string
+	CodecLongName string
+	Profile       string
+	Level         int // as reported by ffprobe, e.g. 40 for H.264 level 4.0
+	Duration      time.Duration
+	Bitrate       int64
+	Language      string
+	Default       bool
+	Tags          map[string]string
+
+	Width              int
+	Height             int
+	DisplayAspectRatio string
+	PixelFormat        string
+	FrameRate          float64
+	Frames             int64
+	Rotation           int
+	ColorSpace         string
+	ColorRange         string
+	ColorTransfer      string
+	ColorPrimaries     string
+
+	SampleRate    int
+	Channels      int
+	ChannelLayout string
+}
+
+type Chapter struct {
+	ID    int64
+	Start time.Duration
+	End   time.Duration
+	Title string
+	Tags  map[string]string
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		Index              int               `json:"index"`
+		CodecType          string            `json:"codec_type"`
+		CodecName          string            `json:"codec_name"`
+		CodecLongName      string            `json:"codec_long_name"`
+		Profile            string            `json:"profile"`
+		Level              int               `json:"level"`
+		Width              int               `json:"width"`
+		Height             int               `json:"height"`
+		DisplayAspectRatio string            `json:"display_aspect_ratio"`
+		PixFmt             string            `json:"pix_fmt"`
+		AvgFrameRate       string            `json:"avg_frame_rate"`
+		RFrameRate         string            `json:"r_frame_rate"`
+		NbFrames           string            `json:"nb_frames"`
+		ColorSpace         string            `json:"color_space"`
+		ColorRange         string            `json:"color_range"`
+		ColorTransfer      string            `json:"color_transfer"`
+		ColorPrimaries     string            `json:"color_primaries"`
+		SampleRate         string            `json:"sample_rate"`
+		Channels           int               `json:"channels"`
+		ChannelLayout      string            `json:"channel_layout"`
+		Duration           string            `json:"duration"`
+		BitRate            string            `json:"bit_rate"`
+		Disposition        map[string]int    `json:"disposition"`
+		Tags               map[string]string `json:"tags"`
+		SideDataList       []struct {
+			Rotation int `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Chapters []struct {
+		ID        int64             `json:"id"`
+		StartTime string            `json:"start_time"`
+		EndTime   string            `json:"end_time"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"chapters"`
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		StartTime      string            `json:"start_time"`
+		Duration       string            `json:"duration"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// Probe runs ffprobe on path and returns its streams, format and chapters.
+func Probe(path string) (*MediaInfo, error) {
+	return ProbeContext(context.Background(), path)
+}
+
+func ProbeContext(ctx context.Context, path string) (*MediaInfo, error) {
+	stdout, stderr, err := DefaultExecutor.Run(ctx, "ffprobe", []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams", "-show_chapters", path})
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %s: %w: %s", path, err, strings.TrimSpace(string(stderr)))
+	}
+	return parseProbeOutput(path, stdout)
+}
+
+func parseProbeOutput(path string, output []byte) (*MediaInfo, error) {
+	var raw ffprobeOutput
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, fmt.Errorf("ffprobe %s: invalid output: %w", path, err)
+	}
+
+	info := &MediaInfo{
+		Path:           path,
+		FormatName:     raw.Format.FormatName,
+		FormatLongName: raw.Format.FormatLongName,
+		Duration:       parseSeconds(raw.Format.Duration),
+		StartTime:      parseSeconds(raw.Format.StartTime),
+		Size:           parseInt(raw.Format.Size),
+		Bitrate:        parseInt(raw.Format.BitRate),
+		Tags:           raw.Format.Tags,
+	}
+
+	for _, s := range raw.Streams {
+		stream := StreamInfo{
+			Index:              s.Index,
+			Type:               s.CodecType,
+			Codec:              s.CodecName,
+			CodecLongName:      s.CodecLongName,
+			Profile:            s.Profile,
+			Level:              s.Level,
+			Duration:           parseSeconds(s.Duration),
+			Bitrate:            parseInt(s.BitRate),
+			Language:           s.Tags["language"],
+			Default:            s.Disposition["default"] == 1,
+			Tags:               s.Tags,
+			Width:              s.Width,
+			Height:             s.Height,
+			DisplayAspectRatio: s.DisplayAspectRatio,
+			PixelFormat:        s.PixFmt,
+			FrameRate:          parseRational(s.AvgFrameRate),
+			Frames:             parseInt(s.NbFrames),
+			ColorSpace:         s.ColorSpace,
+			ColorRange:         s.ColorRange,
+			ColorTransfer:      s.ColorTransfer,
+			ColorPrimaries:     s.ColorPrimaries,
+			SampleRate:         int(parseInt(s.SampleRate)),
+			Channels:           s.Channels,
+			ChannelLayout:      s.ChannelLayout,
+		}
+		if stream.FrameRate == 0 {
+			stream.FrameRate = parseRational(s.RFrameRate)
+		}
+		if rotate, ok := s.Tags["rotate"]; ok {
+			stream.Rotation, _ = strconv.Atoi(rotate)
+		}
+		for _, sideData := range s.SideDataList {
+			if sideData.Rotation != 0 {
+				stream.Rotation = sideData.Rotation
+			}
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+
+	for _, c := range raw.Chapters {
+		info.Chapters = append(info.Chapters, Chapter{
+			ID:    c.ID,
+			Start: parseSeconds(c.StartTime),
+			End:   parseSeconds(c.EndTime),
+			Title: c.Tags["title"],
+			Tags:  c.Tags,
+		})
+	}
+	return info, nil
+}
+
+// StreamsOfType returns the streams of the given type (video, audio, subtitle) in file order.
+func (info *MediaInfo) StreamsOfType(streamType string) []StreamInfo {
+	streams := []StreamInfo{}
+	for _, stream := range info.Streams {
+		if stream.Type == streamType {
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+// VideoStream returns the first video stream, or nil if the file has none.
+func (info *MediaInfo) VideoStream() *StreamInfo {
+	for i := range info.Streams {
+		if info.Streams[i].Type == video {
+			return &info.Streams[i]
+		}
+	}
+	return nil
+}
+
+// AudioStream returns the first audio stream, or nil if the file has none.
+func (info *MediaInfo) AudioStream() *StreamInfo {
+	for i := range info.Streams {
+		if info.Streams[i].Type == audio {
+			return &info.Streams[i]
+		}
+	}
+	return nil
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func parseInt(value string) int64 {
+	parsed, _ := strconv.ParseInt(value, 10, 64)
+	return parsed
+}
+
+// parseRational parses ffprobe fractions such as 30000/1001.
+func parseRational(value string) float64 {
+	numerator, denominator, ok := strings.Cut(value, "/")
+	if !ok {
+		parsed, _ := strconv.ParseFloat(value, 64)
+		return parsed
+	}
+	n, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
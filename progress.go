@@ -0,0 +1,121 @@
+package animax
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is a snapshot of a running render parsed from ffmpeg's -progress output.
+type Progress struct {
+	Stage   int
+	Stages  int
+	Frame   int64
+	FPS     float64
+	Speed   float64
+	OutTime time.Duration
+	Percent float64
+	Done    bool
+}
+
+// ProgressFunc receives progress updates. It is called from the goroutine reading ffmpeg's output,
+// so it should return quickly.
+type ProgressFunc func(progress Progress)
+
+// progressWriter parses the key=value blocks written by `ffmpeg -progress pipe:1`.
+type progressWriter struct {
+	onProgress    ProgressFunc
+	stage         int
+	stages        int
+	stageDuration float64
+	current       Progress
+	buffer        bytes.Buffer
+}
+
+func newProgressWriter(onProgress ProgressFunc, stage int, stages int, stageDuration float64) *progressWriter {
+	return &progressWriter{
+		onProgress:    onProgress,
+		stage:         stage,
+		stages:        stages,
+		stageDuration: stageDuration,
+		current:       Progress{Stage: stage, Stages: stages},
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the partial line for the next write
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+		w.parseLine(strings.TrimSpace(line))
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) parseLine(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+
+	switch key {
+	case "frame":
+		w.current.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		w.current.FPS, _ = strconv.ParseFloat(value, 64)
+	case "speed":
+		w.current.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "out_time_ms", "out_time_us":
+		// ffmpeg reports microseconds under both keys
+		if micro, err := strconv.ParseInt(value, 10, 64); err == nil {
+			w.current.OutTime = time.Duration(micro) * time.Microsecond
+		}
+	case "progress":
+		w.current.Done = value == "end"
+		w.current.Percent = w.percent()
+		w.onProgress(w.current)
+	}
+}
+
+func (w *progressWriter) percent() float64 {
+	fraction := 0.0
+	if w.current.Done {
+		fraction = 1
+	} else if w.stageDuration > 0 {
+		fraction = w.current.OutTime.Seconds() / w.stageDuration
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	if w.stages == 0 {
+		return 0
+	}
+	return (float64(w.stage) + fraction) * 100 / float64(w.stages)
+}
+
+// stageDuration estimates how long the output of a stage is, given the duration of its input.
+func stageDuration(stage []string, inputDuration float64) float64 {
+	start, end := -1.0, -1.0
+	for i := 0; i < len(stage)-1; i++ {
+		switch stage[i] {
+		case "-ss":
+			start, _ = strconv.ParseFloat(stage[i+1], 64)
+		case "-to":
+			end, _ = strconv.ParseFloat(stage[i+1], 64)
+		}
+	}
+
+	if start < 0 || end < 0 || end < start {
+		return inputDuration
+	}
+	if inputDuration > 0 && end-start > inputDuration {
+		return inputDuration
+	}
+	return end - start
+}
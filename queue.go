@@ -0,0 +1,343 @@
+package animax
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrQueueClosed is returned when a job is submitted to a closed RenderQueue.
+var ErrQueueClosed = errors.New("render queue is closed")
+
+// ErrDuplicateJob is returned when a job is submitted with the ID of a job that is still queued or running.
+var ErrDuplicateJob = errors.New("a job with this ID is already queued or running")
+
+// RenderJob is a render submitted to a RenderQueue. File is a Video or an Audio (or a pointer to one).
+type RenderJob struct {
+	ID         string // generated when empty
+	File       File
+	OutputPath string
+	Encoding   string        // ignored for audio
+	Priority   int           // higher priorities run first, equal priorities run in submission order
+	Timeout    time.Duration // bounds the whole job from when it leaves the queue, retries and backoff included
+	Context    context.Context
+	Options    []RenderOption
+}
+
+type JobResult struct {
+	Job      RenderJob
+	Output   File // nil when Err is set
+	Err      error
+	Attempts int
+	Started  time.Time
+	Finished time.Time
+}
+
+/*
+	RetryPolicy decides whether a failed render runs again. By default a job runs once.
+	RetryIf defaults to retrying the failures of ffmpeg (*RenderError) only, so invalid plans such as ErrNoEffects or rejected
+	EncodeOptions fail at once. Jobs that were cancelled or timed out are never retried.
+*/
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	RetryIf     func(err error) bool
+}
+
+type QueueOptions struct {
+	Workers      int // defaults to 1
+	Retry        RetryPolicy
+	ResultBuffer int // size of the results channel, defaults to 64
+
+	// OnStart is called from the worker goroutine when a job leaves the queue, before its first attempt.
+	OnStart func(job RenderJob)
+}
+
+type QueueStats struct {
+	Queued    int
+	Running   int
+	Completed int
+	Failed    int
+}
+
+// renderable is implemented by the files a RenderQueue can render.
+type renderable interface {
+	renderWith(ctx context.Context, outputPath string, encoding string, options []RenderOption) (File, error)
+}
+
+func (video Video) renderWith(ctx context.Context, outputPath string, encoding string, options []RenderOption) (File, error) {
+	output, err := video.RenderContext(ctx, outputPath, encoding, options...)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (audio Audio) renderWith(ctx context.Context, outputPath string, encoding string, options []RenderOption) (File, error) {
+	output, err := audio.RenderContext(ctx, outputPath, options...)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+type queuedJob struct {
+	job      RenderJob
+	sequence int64
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// jobHeap orders jobs by priority, then by submission order.
+type jobHeap []*queuedJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].job.Priority != h[j].job.Priority {
+		return h[i].job.Priority > h[j].job.Priority
+	}
+	return h[i].sequence < h[j].sequence
+}
+func (h jobHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(*queuedJob)) }
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return job
+}
+
+/*
+	RenderQueue renders jobs on a fixed number of workers. Submit, Cancel and the metrics are safe to use from multiple goroutines.
+	Every submitted job produces exactly one JobResult on Results, which must be drained.
+*/
+type RenderQueue struct {
+	options QueueOptions
+	results chan JobResult
+
+	mu       sync.Mutex
+	wake     *sync.Cond
+	pending  jobHeap
+	running  map[string]*queuedJob
+	sequence int64
+	closed   bool
+	stats    QueueStats
+	workers  sync.WaitGroup
+	sends    sync.WaitGroup // results of cancelled queued jobs not sent yet
+}
+
+func NewRenderQueue(options QueueOptions) *RenderQueue {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	if options.ResultBuffer <= 0 {
+		options.ResultBuffer = 64
+	}
+	if options.Retry.MaxAttempts <= 0 {
+		options.Retry.MaxAttempts = 1
+	}
+	if options.Retry.RetryIf == nil {
+		options.Retry.RetryIf = func(err error) bool {
+			var renderErr *RenderError
+			return errors.As(err, &renderErr)
+		}
+	}
+
+	queue := &RenderQueue{
+		options: options,
+		results: make(chan JobResult, options.ResultBuffer),
+		running: make(map[string]*queuedJob),
+	}
+	queue.wake = sync.NewCond(&queue.mu)
+
+	for i := 0; i < options.Workers; i++ {
+		queue.workers.Add(1)
+		go queue.work()
+	}
+	return queue
+}
+
+// Submit queues job and returns its ID.
+func (queue *RenderQueue) Submit(job RenderJob) (string, error) {
+	if _, ok := job.File.(renderable); !ok {
+		return "", errors.New("job file must be a Video or an Audio")
+	}
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	parent := job.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.closed {
+		return "", ErrQueueClosed
+	}
+	if queue.known(job.ID) {
+		return "", ErrDuplicateJob
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	queue.sequence++
+	heap.Push(&queue.pending, &queuedJob{job: job, sequence: queue.sequence, ctx: ctx, cancel: cancel})
+	queue.stats.Queued++
+	queue.wake.Signal()
+	return job.ID, nil
+}
+
+// known reports whether a job with the given ID is queued or running. The caller holds queue.mu.
+func (queue *RenderQueue) known(id string) bool {
+	if _, ok := queue.running[id]; ok {
+		return true
+	}
+	for _, queued := range queue.pending {
+		if queued.job.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Cancel stops the job with the given ID whether it is queued or running. It returns false if the job is unknown or already done.
+func (queue *RenderQueue) Cancel(id string) bool {
+	queue.mu.Lock()
+	if running, ok := queue.running[id]; ok {
+		running.cancel()
+		queue.mu.Unlock()
+		return true
+	}
+	for i, queued := range queue.pending {
+		if queued.job.ID == id {
+			heap.Remove(&queue.pending, i)
+			queue.stats.Queued--
+			queue.stats.Failed++
+			queued.cancel()
+			// the consumer may need the lock to drain Results, so the result is sent once it is released
+			queue.sends.Add(1)
+			queue.mu.Unlock()
+
+			now := time.Now()
+			queue.results <- JobResult{Job: queued.job, Err: context.Canceled, Started: now, Finished: now}
+			queue.sends.Done()
+			return true
+		}
+	}
+	queue.mu.Unlock()
+	return false
+}
+
+func (queue *RenderQueue) Results() <-chan JobResult {
+	return queue.results
+}
+
+// Depth returns the number of jobs waiting for a worker.
+func (queue *RenderQueue) Depth() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return len(queue.pending)
+}
+
+// Running returns the number of jobs being rendered.
+func (queue *RenderQueue) Running() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return len(queue.running)
+}
+
+func (queue *RenderQueue) Stats() QueueStats {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.stats
+}
+
+// Close stops accepting jobs, waits for the queued and running jobs to finish and closes Results.
+func (queue *RenderQueue) Close() {
+	queue.mu.Lock()
+	if queue.closed {
+		queue.mu.Unlock()
+		return
+	}
+	queue.closed = true
+	queue.wake.Broadcast()
+	queue.mu.Unlock()
+
+	queue.workers.Wait()
+	queue.sends.Wait()
+	close(queue.results)
+}
+
+func (queue *RenderQueue) work() {
+	defer queue.workers.Done()
+	for {
+		queue.mu.Lock()
+		for len(queue.pending) == 0 && !queue.closed {
+			queue.wake.Wait()
+		}
+		if len(queue.pending) == 0 {
+			queue.mu.Unlock()
+			return
+		}
+		next := heap.Pop(&queue.pending).(*queuedJob)
+		queue.running[next.job.ID] = next
+		queue.stats.Queued--
+		queue.stats.Running++
+		queue.mu.Unlock()
+
+		if queue.options.OnStart != nil {
+			queue.options.OnStart(next.job)
+		}
+		result := queue.run(next)
+
+		queue.mu.Lock()
+		delete(queue.running, next.job.ID)
+		queue.stats.Running--
+		if result.Err != nil {
+			queue.stats.Failed++
+		} else {
+			queue.stats.Completed++
+		}
+		queue.mu.Unlock()
+
+		queue.results <- result
+	}
+}
+
+func (queue *RenderQueue) run(queued *queuedJob) JobResult {
+	defer queued.cancel()
+	result := JobResult{Job: queued.job, Started: time.Now()}
+	file := queued.job.File.(renderable)
+	ctx := queued.ctx
+	if queued.job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(queued.ctx, queued.job.Timeout)
+		defer cancel()
+	}
+
+	for {
+		result.Attempts++
+		result.Output, result.Err = file.renderWith(ctx, queued.job.OutputPath, queued.job.Encoding, queued.job.Options)
+
+		if result.Err == nil || result.Attempts >= queue.options.Retry.MaxAttempts || ctx.Err() != nil || !queue.options.Retry.RetryIf(result.Err) {
+			break
+		}
+
+		Logger.Warnf("Job %s | Attempt %d failed, retrying | %s", queued.job.ID, result.Attempts, result.Err)
+		select {
+		case <-time.After(queue.options.Retry.Backoff):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			result.Err = ctx.Err()
+			break
+		}
+	}
+
+	result.Finished = time.Now()
+	return result
+}
@@ -0,0 +1,244 @@
+package animax_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pichan321/animax"
+)
+
+// gate holds every ffmpeg call until release is closed or the render is cancelled, and reports the output of the calls it holds.
+type gate struct {
+	recorder *animax.RecordingExecutor
+	started  chan string
+	release  chan struct{}
+}
+
+func newGate() *gate {
+	return &gate{recorder: newRecorder(), started: make(chan string, 16), release: make(chan struct{})}
+}
+
+func (g *gate) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	if name == "ffmpeg" {
+		g.started <- filepath.Base(args[len(args)-1])
+		select {
+		case <-g.release:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	return g.recorder.Run(ctx, name, args)
+}
+
+// queueVideo loads a probed video, which needs an effect before it has something to render.
+func queueVideo(t *testing.T) *animax.Video {
+	t.Helper()
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = newRecorder()
+	t.Cleanup(func() { animax.DefaultExecutor = executor })
+
+	video, err := animax.LoadVideo(touch(t, t.TempDir(), "input.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &video
+}
+
+func submit(t *testing.T, queue *animax.RenderQueue, job animax.RenderJob) string {
+	t.Helper()
+	id, err := queue.Submit(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func result(t *testing.T, queue *animax.RenderQueue) animax.JobResult {
+	t.Helper()
+	select {
+	case result := <-queue.Results():
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result after 5s")
+		return animax.JobResult{}
+	}
+}
+
+func TestQueueRunsHigherPrioritiesFirst(t *testing.T) {
+	video := queueVideo(t).Blur(1)
+	gate := newGate()
+	queue := animax.NewRenderQueue(animax.QueueOptions{})
+	defer queue.Close()
+	dir := t.TempDir()
+	job := func(name string, priority int) animax.RenderJob {
+		return animax.RenderJob{ID: name, File: video, OutputPath: filepath.Join(dir, name+".mp4"), Priority: priority, Options: []animax.RenderOption{animax.WithExecutor(gate)}}
+	}
+
+	// the only worker is busy until every other job is queued
+	submit(t, queue, job("busy", 0))
+	<-gate.started
+	submit(t, queue, job("low", 0))
+	submit(t, queue, job("high", 10))
+	submit(t, queue, job("normal", 5))
+	submit(t, queue, job("high-later", 10))
+	close(gate.release)
+
+	want := []string{"busy", "high", "high-later", "normal", "low"}
+	for _, id := range want {
+		result := result(t, queue)
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if result.Job.ID != id {
+			t.Errorf("job %s finished, want %s (order %v)", result.Job.ID, id, want)
+		}
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	input := queueVideo(t)
+	video := input.Blur(1)
+	failing := &animax.RecordingExecutor{Responses: map[string]animax.Response{"ffmpeg": {Stderr: []byte("Conversion failed!"), Err: errors.New("exit status 1")}}}
+	queue := animax.NewRenderQueue(animax.QueueOptions{Retry: animax.RetryPolicy{MaxAttempts: 3}})
+	defer queue.Close()
+	dir := t.TempDir()
+
+	submit(t, queue, animax.RenderJob{File: video, OutputPath: filepath.Join(dir, "failing.mp4"), Options: []animax.RenderOption{animax.WithExecutor(failing)}})
+	failed := result(t, queue)
+	var renderErr *animax.RenderError
+	if !errors.As(failed.Err, &renderErr) {
+		t.Fatalf("got %v, want a RenderError", failed.Err)
+	}
+	if failed.Attempts != 3 || len(failing.Calls()) != 3 {
+		t.Errorf("ffmpeg failure ran %d attempts and %d commands, want 3", failed.Attempts, len(failing.Calls()))
+	}
+
+	// a plan that cannot render fails the same way every time
+	failing.Reset()
+	submit(t, queue, animax.RenderJob{File: input, OutputPath: filepath.Join(dir, "unchanged.mp4"), Options: []animax.RenderOption{animax.WithExecutor(failing)}})
+	unchanged := result(t, queue)
+	if !errors.Is(unchanged.Err, animax.ErrNoEffects) || unchanged.Attempts != 1 {
+		t.Errorf("got %v after %d attempts, want ErrNoEffects after 1", unchanged.Err, unchanged.Attempts)
+	}
+}
+
+func TestQueueTimeoutCoversEveryAttempt(t *testing.T) {
+	video := queueVideo(t).Blur(1)
+	failing := &animax.RecordingExecutor{Responses: map[string]animax.Response{"ffmpeg": {Err: errors.New("exit status 1")}}}
+	queue := animax.NewRenderQueue(animax.QueueOptions{Retry: animax.RetryPolicy{MaxAttempts: 100, Backoff: 50 * time.Millisecond}})
+	defer queue.Close()
+
+	submit(t, queue, animax.RenderJob{File: video, OutputPath: filepath.Join(t.TempDir(), "output.mp4"), Timeout: 200 * time.Millisecond, Options: []animax.RenderOption{animax.WithExecutor(failing)}})
+	result := result(t, queue)
+	if !errors.Is(result.Err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", result.Err)
+	}
+	if result.Attempts >= 100 || result.Finished.Sub(result.Started) > 2*time.Second {
+		t.Errorf("job ran %d attempts in %s, want the timeout to end it", result.Attempts, result.Finished.Sub(result.Started))
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	video := queueVideo(t).Blur(1)
+	gate := newGate()
+	queue := animax.NewRenderQueue(animax.QueueOptions{})
+	defer queue.Close()
+	dir := t.TempDir()
+	options := []animax.RenderOption{animax.WithExecutor(gate)}
+
+	running := submit(t, queue, animax.RenderJob{File: video, OutputPath: filepath.Join(dir, "running.mp4"), Options: options})
+	<-gate.started
+	queued := submit(t, queue, animax.RenderJob{File: video, OutputPath: filepath.Join(dir, "queued.mp4"), Options: options})
+
+	if !queue.Cancel(queued) {
+		t.Fatal("queued job was not cancelled")
+	}
+	if cancelled := result(t, queue); cancelled.Job.ID != queued || !errors.Is(cancelled.Err, context.Canceled) || cancelled.Attempts != 0 {
+		t.Errorf("got %s after %d attempts with %v, want %s cancelled before running", cancelled.Job.ID, cancelled.Attempts, cancelled.Err, queued)
+	}
+
+	if !queue.Cancel(running) {
+		t.Fatal("running job was not cancelled")
+	}
+	if cancelled := result(t, queue); cancelled.Job.ID != running || !errors.Is(cancelled.Err, context.Canceled) {
+		t.Errorf("got %s with %v, want %s cancelled", cancelled.Job.ID, cancelled.Err, running)
+	}
+
+	if queue.Cancel(running) || queue.Cancel("unknown") {
+		t.Error("Cancel reported a finished or unknown job as cancelled")
+	}
+	if stats := queue.Stats(); stats.Failed != 2 || stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("stats %+v, want 2 failed jobs", stats)
+	}
+}
+
+func TestQueueCloseDrains(t *testing.T) {
+	video := queueVideo(t).Blur(1)
+	recorder := newRecorder()
+	queue := animax.NewRenderQueue(animax.QueueOptions{Workers: 2})
+	dir := t.TempDir()
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		submit(t, queue, animax.RenderJob{File: video, OutputPath: filepath.Join(dir, name+".mp4"), Options: []animax.RenderOption{animax.WithExecutor(recorder)}})
+	}
+	go queue.Close()
+
+	finished := 0
+	for result := range queue.Results() {
+		if result.Err != nil {
+			t.Error(result.Err)
+		}
+		finished++
+	}
+	if finished != 5 {
+		t.Errorf("%d results before Results was closed, want 5", finished)
+	}
+	if stats := queue.Stats(); stats.Completed != 5 {
+		t.Errorf("stats %+v, want 5 completed jobs", stats)
+	}
+	if _, err := queue.Submit(animax.RenderJob{File: video, OutputPath: filepath.Join(dir, "late.mp4")}); !errors.Is(err, animax.ErrQueueClosed) {
+		t.Errorf("submit after Close returned %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestQueueMetricsAndDuplicateIDs(t *testing.T) {
+	video := queueVideo(t).Blur(1)
+	gate := newGate()
+	queue := animax.NewRenderQueue(animax.QueueOptions{})
+	defer queue.Close()
+	dir := t.TempDir()
+	job := func(id string) animax.RenderJob {
+		return animax.RenderJob{ID: id, File: video, OutputPath: filepath.Join(dir, id+".mp4"), Options: []animax.RenderOption{animax.WithExecutor(gate)}}
+	}
+
+	submit(t, queue, job("a"))
+	<-gate.started
+	submit(t, queue, job("b"))
+	if queue.Depth() != 1 || queue.Running() != 1 {
+		t.Errorf("depth %d and %d running, want 1 and 1", queue.Depth(), queue.Running())
+	}
+	if stats := queue.Stats(); stats.Queued != 1 || stats.Running != 1 {
+		t.Errorf("stats %+v, want 1 queued and 1 running", stats)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if _, err := queue.Submit(job(id)); !errors.Is(err, animax.ErrDuplicateJob) {
+			t.Errorf("submitting %s again returned %v, want ErrDuplicateJob", id, err)
+		}
+	}
+
+	close(gate.release)
+	result(t, queue)
+	result(t, queue)
+	if stats := queue.Stats(); stats.Completed != 2 || stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("stats %+v, want 2 completed jobs", stats)
+	}
+	// the ID is free again once the job is done
+	submit(t, queue, job("a"))
+	if result := result(t, queue); result.Err != nil {
+		t.Error(result.Err)
+	}
+}
@@ -0,0 +1,338 @@
+package recipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pichan321/animax"
+	util "github.com/pichan321/animax/utilities"
+)
+
+// ApplyRecipe builds the effect chain described by recipe, renders it and returns the output file.
+func ApplyRecipe(recipe Recipe, options ...animax.RenderOption) (animax.File, error) {
+	return ApplyRecipeContext(context.Background(), recipe, options...)
+}
+
+/*
+	Same as ApplyRecipe but stops as soon as ctx is cancelled.
+	Operations are applied in order. overlay and concat work on files, so the effects chained before them are rendered
+	to a temporary file first and the operations after them are applied to their result.
+*/
+func ApplyRecipeContext(ctx context.Context, recipe Recipe, options ...animax.RenderOption) (animax.File, error) {
+	if err := recipe.Validate(); err != nil {
+		return nil, err
+	}
+	if animax.FileType(recipe.Input) == "audio" {
+		return applyAudio(ctx, recipe, options)
+	}
+	return applyVideo(ctx, recipe, options)
+}
+
+func applyVideo(ctx context.Context, recipe Recipe, options []animax.RenderOption) (animax.File, error) {
+	source, err := animax.LoadVideo(recipe.Input)
+	if err != nil {
+		return nil, recipe.errorAt(recipe.lines["input"], err)
+	}
+
+	workingDir := uuid.New().String()
+	if err := os.MkdirAll(workingDir, 0755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workingDir)
+
+	chain := &source
+	pending := false // whether chain holds effects that are not rendered yet
+	for index, operation := range recipe.Operations {
+		switch operation.Op {
+		case "overlay", "concat":
+			if pending {
+				rendered, err := chain.RenderContext(ctx, filepath.Join(workingDir, fmt.Sprintf("step-%d-input.mp4", index)), "", options...)
+				if err != nil {
+					return nil, recipe.errorAt(operation.Line, err)
+				}
+				chain, pending = &rendered, false
+			}
+
+			output := filepath.Join(workingDir, fmt.Sprintf("step-%d.mp4", index))
+			if err := runFileStep(ctx, operation, *chain, output); err != nil {
+				return nil, recipe.errorAt(operation.Line, err)
+			}
+			result, err := animax.LoadVideo(output)
+			if err != nil {
+				return nil, recipe.errorAt(operation.Line, err)
+			}
+			chain = &result
+		default:
+			chain, err = applyVideoEffect(chain, operation)
+			if err != nil {
+				return nil, recipe.errorAt(operation.Line, err)
+			}
+			pending = true
+		}
+	}
+
+	if pending {
+		encode := recipe.Output.encodeOptions()
+		output, err := chain.RenderContext(ctx, recipe.Output.Path, encode.Codec, append(options, animax.WithEncodeOptions(encode))...)
+		if err != nil {
+			return nil, recipe.errorAt(recipe.lines["output"], err)
+		}
+		return output, nil
+	}
+
+	// the last operation was overlay or concat, its result only has to be moved
+	os.Remove(recipe.Output.Path)
+	if err := os.Rename(chain.FilePath, recipe.Output.Path); err != nil {
+		return nil, recipe.errorAt(recipe.lines["output"], err)
+	}
+	output, err := animax.LoadVideo(recipe.Output.Path)
+	if err != nil {
+		return nil, recipe.errorAt(recipe.lines["output"], err)
+	}
+	return output, nil
+}
+
+// runFileStep runs the operations that work on rendered files rather than on the effect chain.
+func runFileStep(ctx context.Context, operation Operation, video animax.Video, outputPath string) error {
+	switch operation.Op {
+	case "overlay":
+		if logo := operation.text("logo"); logo != "" {
+			return util.AddOverlayBackgroundAndLogoContext(ctx, video, logo, outputPath)
+		}
+		return util.AddOverlayBackgroundContext(ctx, video, outputPath)
+	case "concat":
+		videos := []animax.Video{video}
+		for _, input := range operation.textList("inputs") {
+			next, err := animax.LoadVideo(input)
+			if err != nil {
+				return fmt.Errorf("concat: %s", err)
+			}
+			videos = append(videos, next)
+		}
+		return util.ConcatenateVideosContext(ctx, videos, operation.boolean("encode", true), outputPath)
+	}
+	return fmt.Errorf("%s is not a file operation", operation.Op)
+}
+
+func applyAudio(ctx context.Context, recipe Recipe, options []animax.RenderOption) (animax.File, error) {
+	source, err := animax.LoadAudio(recipe.Input)
+	if err != nil {
+		return nil, recipe.errorAt(recipe.lines["input"], err)
+	}
+
+	chain := &source
+	for _, operation := range recipe.Operations {
+		chain, err = applyAudioEffect(chain, operation)
+		if err != nil {
+			return nil, recipe.errorAt(operation.Line, err)
+		}
+	}
+
+	output, err := chain.RenderContext(ctx, recipe.Output.Path, recipe.Output.audioOptions(options)...)
+	if err != nil {
+		return nil, recipe.errorAt(recipe.lines["output"], err)
+	}
+	return output, nil
+}
+
+/*
+	applyVideoEffect adds operation to chain. Effects that fail log the reason and return an empty video, which is reported
+	here so the error points at the operation instead of a later ffmpeg failure.
+*/
+func applyVideoEffect(chain *animax.Video, operation Operation) (*animax.Video, error) {
+	modified, err := videoEffect(chain, operation)
+	if err != nil {
+		return nil, err
+	}
+	if modified.FilePath == "" {
+		return nil, fmt.Errorf("unable to apply %s to %s", operation.Op, chain.FilePath)
+	}
+	return modified, nil
+}
+
+func videoEffect(chain *animax.Video, operation Operation) (*animax.Video, error) {
+	switch operation.Op {
+	case "trim":
+		start, err := videoDuration(*chain, operation, "start")
+		if err != nil {
+			return nil, err
+		}
+		end, err := videoDuration(*chain, operation, "end")
+		if err != nil {
+			return nil, err
+		}
+		// frame numbers are only resolved here, so Validate cannot compare them
+		if start > end {
+			return nil, errors.New("trim start cannot be after its end")
+		}
+		return chain.TrimRange(start, end), nil
+	case "resize":
+		width, height := operation.integer("width"), operation.integer("height")
+		switch {
+		case height <= 0:
+			return chain.ResizeByWidth(width), nil
+		case width <= 0:
+			return chain.ResizeByHeight(height), nil
+		}
+		return chain.Resize(width, height), nil
+	case "crop":
+		return chain.Crop(operation.integer("width"), operation.integer("height"), operation.integer("x"), operation.integer("y")), nil
+	case "blur":
+		return chain.Blur(int16(operation.integer("intensity"))), nil
+	case "saturate":
+		return chain.Saturate(operation.number("multiplier")), nil
+	case "volume":
+		return chain.ChangeVolume(operation.number("multiplier")), nil
+	case "text":
+		start, err := videoDuration(*chain, operation, "start")
+		if err != nil {
+			return nil, err
+		}
+		end, err := videoDuration(*chain, operation, "end")
+		if err != nil {
+			return nil, err
+		}
+		return chain.DrawText(animax.TextOverlay{
+			Text:        operation.text("text"),
+			FontFile:    operation.text("font"),
+			FontSize:    int(operation.integer("size")),
+			Color:       operation.text("color"),
+			BorderWidth: int(operation.integer("border")),
+			Box:         operation.boolean("box", false),
+			Position:    operation.text("position"),
+			Start:       start,
+			End:         end,
+			FadeIn:      time.Duration(operation.number("fade_in") * float64(time.Second)),
+			FadeOut:     time.Duration(operation.number("fade_out") * float64(time.Second)),
+		}), nil
+	case "subtitles":
+		file := operation.text("file")
+		if operation.boolean("burn", true) {
+			return chain.BurnSubtitles(file, animax.SubtitleStyle{
+				FontName: operation.text("font"),
+				FontSize: int(operation.integer("size")),
+				Color:    operation.text("color"),
+				Position: operation.text("position"),
+			}), nil
+		}
+		return chain.AddSubtitleTrack(file, operation.text("language")), nil
+	}
+	return nil, fmt.Errorf("%s cannot be applied to video", operation.Op)
+}
+
+// applyAudioEffect adds operation to chain and reports the effects that fail, like applyVideoEffect.
+func applyAudioEffect(chain *animax.Audio, operation Operation) (*animax.Audio, error) {
+	modified, err := audioEffect(chain, operation)
+	if err != nil {
+		return nil, err
+	}
+	if modified.FilePath == "" {
+		return nil, fmt.Errorf("unable to apply %s to %s", operation.Op, chain.FilePath)
+	}
+	return modified, nil
+}
+
+func audioEffect(chain *animax.Audio, operation Operation) (*animax.Audio, error) {
+	switch operation.Op {
+	case "trim":
+		start, err := operation.duration("start", 0)
+		if err != nil {
+			return nil, err
+		}
+		end, err := operation.duration("end", 0)
+		if err != nil {
+			return nil, err
+		}
+		return chain.TrimRange(start, end), nil
+	case "volume":
+		return chain.ChangeVolume(operation.number("multiplier")), nil
+	case "nightcore":
+		return chain.Nightcore(), nil
+	case "bassboost":
+		return chain.BassBoost(), nil
+	}
+	return nil, fmt.Errorf("%s cannot be applied to audio", operation.Op)
+}
+
+func (output Output) audioOptions(options []animax.RenderOption) []animax.RenderOption {
+	if output.AudioBitrate == "" {
+		return options
+	}
+	return append(options, animax.WithEncodeOptions(animax.EncodeOptions{AudioBitrate: output.AudioBitrate}))
+}
+
+/*
+	Job builds the effect chain of the recipe and returns it as a job for an animax.RenderQueue.
+	Recipes using overlay or concat cannot become a single job since those operations need the files rendered before them.
+*/
+func (recipe Recipe) Job() (animax.RenderJob, error) {
+	if err := recipe.Validate(); err != nil {
+		return animax.RenderJob{}, err
+	}
+	for _, operation := range recipe.Operations {
+		if operation.Op == "overlay" || operation.Op == "concat" {
+			return animax.RenderJob{}, recipe.errorAt(operation.Line, fmt.Errorf("%s runs on rendered files and cannot be planned", operation.Op))
+		}
+	}
+
+	if animax.FileType(recipe.Input) == "audio" {
+		source, err := animax.LoadAudio(recipe.Input)
+		if err != nil {
+			return animax.RenderJob{}, recipe.errorAt(recipe.lines["input"], err)
+		}
+		chain := &source
+		for _, operation := range recipe.Operations {
+			if chain, err = applyAudioEffect(chain, operation); err != nil {
+				return animax.RenderJob{}, recipe.errorAt(operation.Line, err)
+			}
+		}
+		return animax.RenderJob{File: chain, OutputPath: recipe.Output.Path, Options: recipe.Output.audioOptions(nil)}, nil
+	}
+
+	source, err := animax.LoadVideo(recipe.Input)
+	if err != nil {
+		return animax.RenderJob{}, recipe.errorAt(recipe.lines["input"], err)
+	}
+	chain := &source
+	for _, operation := range recipe.Operations {
+		if chain, err = applyVideoEffect(chain, operation); err != nil {
+			return animax.RenderJob{}, recipe.errorAt(operation.Line, err)
+		}
+	}
+	encode := recipe.Output.encodeOptions()
+	return animax.RenderJob{
+		File:       chain,
+		OutputPath: recipe.Output.Path,
+		Encoding:   encode.Codec,
+		Options:    []animax.RenderOption{animax.WithEncodeOptions(encode)},
+	}, nil
+}
+
+// Plan returns the ffmpeg invocations ApplyRecipe would run, without running them. See Job for the recipes that can be planned.
+func (recipe Recipe) Plan(options ...animax.RenderOption) (animax.RenderPlan, error) {
+	job, err := recipe.Job()
+	if err != nil {
+		return animax.RenderPlan{}, err
+	}
+	options = append(job.Options, options...)
+	switch file := job.File.(type) {
+	case *animax.Audio:
+		return file.Plan(job.OutputPath, options...)
+	case *animax.Video:
+		return file.Plan(job.OutputPath, job.Encoding, options...)
+	}
+	return animax.RenderPlan{}, fmt.Errorf("cannot plan %T", job.File)
+}
+
+// videoDuration reads a time parameter, resolving frame numbers with the frame rate of video.
+func videoDuration(video animax.Video, operation Operation, name string) (time.Duration, error) {
+	if code, ok := operation.Params[name].(string); ok {
+		return video.ParseTimecode(code)
+	}
+	return operation.duration(name, 0)
+}
@@ -0,0 +1,302 @@
+package recipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	A Recipe describes an edit without code: the input file, the operations to apply in order and the output.
+
+		{
+			"input": "shin.mp4",
+			"operations": [
+				{"op": "trim", "start": "00:01:00", "end": "00:02:30"},
+				{"op": "saturate", "multiplier": 1.5},
+				{"op": "overlay"}
+			],
+			"output": {"path": "short.mp4", "encoding": "best", "crf": 20}
+		}
+*/
+type Recipe struct {
+	Input      string
+	Operations []Operation
+	Output     Output
+
+	name  string         // file name used in errors
+	lines map[string]int // line of each top-level key
+}
+
+// Operation is one step of a recipe. Params holds every field of the step except "op".
+type Operation struct {
+	Op     string
+	Params map[string]interface{}
+	Line   int
+}
+
+type Output struct {
+	Path         string `json:"path" yaml:"path"`
+	Encoding     string `json:"encoding" yaml:"encoding"` // best, efficient, compressed or the name of an encoder
+	CRF          int    `json:"crf" yaml:"crf"`
+	Preset       string `json:"preset" yaml:"preset"`
+	Bitrate      string `json:"bitrate" yaml:"bitrate"`
+	AudioBitrate string `json:"audio_bitrate" yaml:"audio_bitrate"`
+	TwoPass      bool   `json:"two_pass" yaml:"two_pass"`
+}
+
+var outputFields = []string{"path", "encoding", "crf", "preset", "bitrate", "audio_bitrate", "two_pass"}
+
+// Error points at the line of the recipe a problem comes from.
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (recipe Recipe) errorAt(line int, err error) error {
+	return &Error{File: recipe.name, Line: line, Err: err}
+}
+
+/*
+	New builds a recipe from parts that are already decoded, e.g. from the body of an API request, and validates it.
+	Operations without Op take it from their "op" param. name is used in errors.
+*/
+func New(name string, input string, operations []Operation, output Output) (Recipe, error) {
+	recipe := Recipe{Input: input, Output: output, name: name, lines: make(map[string]int)}
+	for _, operation := range operations {
+		if operation.Op == "" {
+			// copied so the params of the caller keep their "op"
+			params := make(map[string]interface{}, len(operation.Params))
+			for key, value := range operation.Params {
+				params[key] = value
+			}
+			operation = newOperation(params, operation.Line)
+		}
+		operation.Op = strings.ToLower(operation.Op)
+		recipe.Operations = append(recipe.Operations, operation)
+	}
+	return recipe, recipe.Validate()
+}
+
+// LoadRecipe reads and validates a .json, .yaml or .yml recipe.
+func LoadRecipe(path string) (Recipe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Recipe{}, err
+	}
+	return ParseRecipe(data, path)
+}
+
+// ParseRecipe parses and validates a recipe. The extension of name picks the format; anything but .yaml and .yml is read as JSON.
+func ParseRecipe(data []byte, name string) (recipe Recipe, err error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		recipe, err = parseYAML(data, name)
+	default:
+		recipe, err = parseJSON(data, name)
+	}
+	if err != nil {
+		return Recipe{}, err
+	}
+	return recipe, recipe.Validate()
+}
+
+func parseJSON(data []byte, name string) (Recipe, error) {
+	recipe := Recipe{name: name, lines: make(map[string]int)}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	fail := func(err error) (Recipe, error) {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return Recipe{}, recipe.errorAt(lineAt(data, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return Recipe{}, recipe.errorAt(lineAt(data, typeErr.Offset), err)
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			return Recipe{}, recipe.errorAt(lineAt(data, int64(len(data))), errors.New("unexpected end of recipe"))
+		}
+		return Recipe{}, recipe.errorAt(lineAt(data, decoder.InputOffset()), err)
+	}
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return fail(err)
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fail(err)
+		}
+		key := token.(string)
+		line := lineAt(data, nextValue(data, decoder.InputOffset()))
+		recipe.lines[key] = line
+
+		switch key {
+		case "input":
+			err = decoder.Decode(&recipe.Input)
+		case "output":
+			err = decoder.Decode(&recipe.Output)
+			if err != nil && !isPositioned(err) {
+				return Recipe{}, recipe.errorAt(line, err)
+			}
+		case "operations":
+			err = parseJSONOperations(decoder, data, &recipe)
+		default:
+			return Recipe{}, recipe.errorAt(line, fmt.Errorf("unknown field %q", key))
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return fail(err)
+	}
+	return recipe, nil
+}
+
+func parseJSONOperations(decoder *json.Decoder, data []byte, recipe *Recipe) error {
+	if err := expectDelim(decoder, '['); err != nil {
+		return err
+	}
+	for decoder.More() {
+		line := lineAt(data, nextValue(data, decoder.InputOffset()))
+		params := map[string]interface{}{}
+		if err := decoder.Decode(&params); err != nil {
+			return err
+		}
+		recipe.Operations = append(recipe.Operations, newOperation(params, line))
+	}
+	return expectDelim(decoder, ']')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %q, found %v", delim, token)
+	}
+	return nil
+}
+
+func isPositioned(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// nextValue skips the whitespace and separators between offset and the next JSON value.
+func nextValue(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+		offset++
+	}
+	return offset
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func parseYAML(data []byte, name string) (Recipe, error) {
+	recipe := Recipe{name: name, lines: make(map[string]int)}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return Recipe{}, &Error{File: name, Err: err} // yaml errors already carry the line
+	}
+	if len(document.Content) == 0 {
+		return Recipe{}, recipe.errorAt(0, errors.New("empty recipe"))
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return Recipe{}, recipe.errorAt(root.Line, errors.New("a recipe must be a mapping"))
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		recipe.lines[key.Value] = key.Line
+
+		var err error
+		switch key.Value {
+		case "input":
+			err = value.Decode(&recipe.Input)
+		case "output":
+			if line, err := checkYAMLFields(value, outputFields); err != nil {
+				return Recipe{}, recipe.errorAt(line, err)
+			}
+			err = value.Decode(&recipe.Output)
+		case "operations":
+			if value.Kind != yaml.SequenceNode {
+				return Recipe{}, recipe.errorAt(value.Line, errors.New("operations must be a list"))
+			}
+			for _, item := range value.Content {
+				params := map[string]interface{}{}
+				if err := item.Decode(&params); err != nil {
+					return Recipe{}, recipe.errorAt(item.Line, err)
+				}
+				recipe.Operations = append(recipe.Operations, newOperation(params, item.Line))
+			}
+		default:
+			return Recipe{}, recipe.errorAt(key.Line, fmt.Errorf("unknown field %q", key.Value))
+		}
+		if err != nil {
+			return Recipe{}, recipe.errorAt(value.Line, err)
+		}
+	}
+	return recipe, nil
+}
+
+// checkYAMLFields returns the line of the first field of node missing from fields.
+func checkYAMLFields(node *yaml.Node, fields []string) (int, error) {
+	if node.Kind != yaml.MappingNode {
+		return node.Line, errors.New("expected a mapping")
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if !containsString(fields, node.Content[i].Value) {
+			return node.Content[i].Line, fmt.Errorf("unknown field %q", node.Content[i].Value)
+		}
+	}
+	return 0, nil
+}
+
+func newOperation(params map[string]interface{}, line int) Operation {
+	operation := Operation{Params: params, Line: line}
+	if op, ok := params["op"].(string); ok {
+		operation.Op = strings.ToLower(op)
+	}
+	delete(params, "op")
+	return operation
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
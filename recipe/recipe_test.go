@@ -0,0 +1,324 @@
+package recipe_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pichan321/animax"
+	"github.com/pichan321/animax/recipe"
+)
+
+const videoProbe = `{"streams": [{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "r_frame_rate": "30/1", "avg_frame_rate": "30/1", "nb_frames": "3600"}, {"index": 1, "codec_type": "audio", "codec_name": "aac"}], "format": {"duration": "120.0"}}`
+
+func TestParseRecipeReportsLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		line    int
+		message string
+	}{
+		{
+			name: "unknown operation",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [
+		{"op": "trim", "end": 10},
+		{"op": "sharpen"}
+	],
+	"output": {"path": "out.mp4"}
+}`,
+			line:    5,
+			message: `unknown operation "sharpen"`,
+		},
+		{
+			name: "negative volume",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [
+		{"op": "volume", "multiplier": -1}
+	],
+	"output": {"path": "out.mp4"}
+}`,
+			line:    4,
+			message: `"multiplier" cannot be negative`,
+		},
+		{
+			name: "negative crop offset",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [
+		{"op": "blur", "intensity": 2},
+		{"op": "crop", "width": 640, "height": 360, "x": -10}
+	],
+	"output": {"path": "out.mp4"}
+}`,
+			line:    5,
+			message: `"x" cannot be negative`,
+		},
+		{
+			name: "fractional width",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [
+		{
+			"op": "resize",
+			"width": 720.9
+		}
+	],
+	"output": {"path": "out.mp4"}
+}`,
+			line:    4,
+			message: `"width" must be a whole number`,
+		},
+		{
+			name: "negative trim start",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [{"op": "trim", "start": -5, "end": 10}],
+	"output": {"path": "out.mp4"}
+}`,
+			line:    3,
+			message: `"start" cannot be negative`,
+		},
+		{
+			name: "syntax error",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [
+		{"op": "blur" "intensity": 2}
+	]
+}`,
+			line: 4,
+		},
+		{
+			name: "wrong output type",
+			file: "recipe.json",
+			data: `{
+	"input": "shin.mp4",
+	"operations": [{"op": "blur", "intensity": 2}],
+	"output": {"path": "out.mp3"}
+}`,
+			line:    4,
+			message: "must be a video file like the input",
+		},
+		{
+			name: "infinite volume",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+  - op: trim
+    end: 10
+  - op: volume
+    multiplier: .inf
+output:
+  path: out.mp4
+`,
+			line:    5,
+			message: `"multiplier" must be a finite number`,
+		},
+		{
+			name: "NaN trim end",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+  - op: trim
+    end: .nan
+output:
+  path: out.mp4
+`,
+			line:    3,
+			message: `"end" must be a finite number`,
+		},
+		{
+			name: "non-finite timecode",
+			file: "recipe.yml",
+			data: `input: shin.mp4
+operations:
+  - op: saturate
+    multiplier: 1.5
+  - op: trim
+    end: "1e400"
+output:
+  path: out.mp4
+`,
+			line: 5,
+		},
+		{
+			name: "unknown param",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+  - op: blur
+    radius: 3
+output:
+  path: out.mp4
+`,
+			line:    3,
+			message: `blur does not take "radius"`,
+		},
+		{
+			name: "blur out of range",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+  - op: resize
+    width: 720
+  - op: blur
+    intensity: 60
+output:
+  path: out.mp4
+`,
+			line:    5,
+			message: "between 0 and 50",
+		},
+		{
+			name: "trim ends before it starts",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+  - op: trim
+    start: "00:01:00"
+    end: 30
+output:
+  path: out.mp4
+`,
+			line:    3,
+			message: "trim start cannot be after its end",
+		},
+		{
+			name: "missing param",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+
+  - op: crop
+    width: 640
+output:
+  path: out.mp4
+`,
+			line:    4,
+			message: `crop needs "height"`,
+		},
+		{
+			name: "audio effect on a video",
+			file: "recipe.yaml",
+			data: `input: shin.mp4
+operations:
+  - op: nightcore
+output:
+  path: out.mp4
+`,
+			line:    3,
+			message: "nightcore cannot be applied to video",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := recipe.ParseRecipe([]byte(test.data), test.file)
+			var recipeErr *recipe.Error
+			if !errors.As(err, &recipeErr) {
+				t.Fatalf("got %v, want a recipe.Error", err)
+			}
+			if recipeErr.Line != test.line || recipeErr.File != test.file {
+				t.Errorf("error at %s:%d, want %s:%d (%s)", recipeErr.File, recipeErr.Line, test.file, test.line, err)
+			}
+			if !strings.Contains(err.Error(), test.message) {
+				t.Errorf("got %q, want it to mention %q", err, test.message)
+			}
+		})
+	}
+}
+
+func TestApplyRecipe(t *testing.T) {
+	recorder := &animax.RecordingExecutor{
+		CreateOutputs: true,
+		Handler: func(cmd animax.Command) animax.Response {
+			if cmd.Name == "ffprobe" {
+				return animax.Response{Stdout: []byte(videoProbe)}
+			}
+			return animax.Response{}
+		},
+	}
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+	defer func() { animax.DefaultExecutor = executor }()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.mp4")
+	if err := os.WriteFile(input, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output.mp4")
+	parse := func(operations string) recipe.Recipe {
+		t.Helper()
+		parsed, err := recipe.ParseRecipe([]byte("input: "+input+"\noperations:\n"+operations+"output:\n  path: "+output+"\n  crf: 20\n"), "recipe.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	t.Run("renders the chain in one pass", func(t *testing.T) {
+		recorder.Reset()
+		parsed := parse("  - op: trim\n    start: f300\n    end: f600\n  - op: blur\n    intensity: 5\n  - op: volume\n    multiplier: 0.5\n")
+		if _, err := recipe.ApplyRecipe(parsed, animax.WithExecutor(recorder)); err != nil {
+			t.Fatal(err)
+		}
+
+		ran := [][]string{}
+		for _, call := range recorder.Calls() {
+			if call.Name == "ffmpeg" {
+				ran = append(ran, call.Args)
+			}
+		}
+		if len(ran) != 1 {
+			t.Fatalf("ran %d ffmpeg commands, want 1", len(ran))
+		}
+		// frames 300 to 600 at 30 fps
+		want := []string{
+			"-ss", "10.000000", "-to", "20.000000", "-i", input,
+			"-filter_complex", "[0:v]boxblur=5[v];[0:a]volume=0.500000[a]", "-map", "[v]", "-map", "[a]",
+			"-c:v", "libx264", "-crf", "20",
+		}
+		if args := ran[0]; len(args) < len(want) || !reflect.DeepEqual(args[:len(want)], want) {
+			t.Errorf("ran\n%q\nwant it to start with\n%q", args, want)
+		}
+	})
+
+	t.Run("reports the line of operations that fail once the input is loaded", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			operations string
+			line       int
+			message    string
+		}{
+			{"trim by frames ending before it starts", "  - op: blur\n    intensity: 2\n  - op: trim\n    start: f300\n    end: f100\n", 5, "trim start cannot be after its end"},
+			{"missing subtitles", "  - op: subtitles\n    file: " + filepath.Join(dir, "missing.srt") + "\n", 3, "unable to apply subtitles"},
+		}
+		for _, test := range tests {
+			recorder.Reset()
+			_, err := recipe.ApplyRecipe(parse(test.operations), animax.WithExecutor(recorder))
+			var recipeErr *recipe.Error
+			if !errors.As(err, &recipeErr) || recipeErr.Line != test.line || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("%s: got %v, want %q at line %d", test.name, err, test.message, test.line)
+			}
+			for _, call := range recorder.Calls() {
+				if call.Name == "ffmpeg" {
+					t.Errorf("%s: ran ffmpeg %q", test.name, call.Args)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pichan321/animax"
+)
+
+type paramKind int
+
+const (
+	number paramKind = iota
+	integer
+	timecode // seconds or a timecode accepted by animax.ParseTimecode
+	text
+	textList
+	boolean
+	path     // a file the operation reads
+	pathList
+)
+
+type param struct {
+	name     string
+	kind     paramKind
+	required bool
+}
+
+// operationSpec lists the file types an operation applies to and the parameters it takes.
+type operationSpec struct {
+	video  bool
+	audio  bool
+	params []param
+}
+
+var operations = map[string]operationSpec{
+	"trim":      {video: true, audio: true, params: []param{{"start", timecode, false}, {"end", timecode, true}}},
+	"resize":    {video: true, params: []param{{"width", integer, false}, {"height", integer, false}}},
+	"crop":      {video: true, params: []param{{"width", integer, true}, {"height", integer, true}, {"x", integer, false}, {"y", integer, false}}},
+	"blur":      {video: true, params: []param{{"intensity", integer, true}}},
+	"saturate":  {video: true, params: []param{{"multiplier", number, true}}},
+	"volume":    {video: true, audio: true, params: []param{{"multiplier", number, true}}},
+	"nightcore": {audio: true},
+	"bassboost": {audio: true},
+	"overlay":   {video: true, params: []param{{"logo", path, false}}},
+	"concat":    {video: true, params: []param{{"inputs", pathList, true}, {"encode", boolean, false}}},
+	"text": {video: true, params: []param{
+		{"text", text, true}, {"font", path, false}, {"size", integer, false}, {"color", text, false},
+		{"border", integer, false}, {"box", boolean, false}, {"position", text, false},
+		{"start", timecode, false}, {"end", timecode, false}, {"fade_in", number, false}, {"fade_out", number, false},
+	}},
+	"subtitles": {video: true, params: []param{
+		{"file", path, true}, {"burn", boolean, false}, {"language", text, false},
+		{"font", text, false}, {"size", integer, false}, {"color", text, false}, {"position", text, false},
+	}},
+}
+
+var subtitleExtensions = []string{".srt", ".vtt", ".ass", ".ssa"}
+
+var textPositions = []string{
+	animax.TEXT_POSITIONS.TopLeft, animax.TEXT_POSITIONS.TopCenter, animax.TEXT_POSITIONS.TopRight,
+	animax.TEXT_POSITIONS.CenterLeft, animax.TEXT_POSITIONS.Center, animax.TEXT_POSITIONS.CenterRight,
+	animax.TEXT_POSITIONS.BottomLeft, animax.TEXT_POSITIONS.BottomCenter, animax.TEXT_POSITIONS.BottomRight,
+}
+
+// Files returns the files the operations read besides the input, e.g. fonts and logos, so callers can check where they are.
+func (recipe Recipe) Files() []string {
+	files := []string{}
+	for _, operation := range recipe.Operations {
+		for _, param := range operations[operation.Op].params {
+			switch param.kind {
+			case path:
+				if file := operation.text(param.name); file != "" {
+					files = append(files, file)
+				}
+			case pathList:
+				files = append(files, operation.textList(param.name)...)
+			}
+		}
+	}
+	return files
+}
+
+// SupportedOperations returns the names of the operations a recipe can use.
+func SupportedOperations() []string {
+	names := []string{}
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks every operation against the effects the input supports, without touching any file.
+func (recipe Recipe) Validate() error {
+	fileType := animax.FileType(recipe.Input)
+	switch {
+	case recipe.Input == "":
+		return recipe.errorAt(recipe.lines["input"], errors.New("input is required"))
+	case fileType == "":
+		return recipe.errorAt(recipe.lines["input"], fmt.Errorf("%s is neither a supported video nor audio file", recipe.Input))
+	case len(recipe.Operations) == 0:
+		return recipe.errorAt(recipe.lines["operations"], errors.New("at least one operation is required"))
+	}
+
+	for _, operation := range recipe.Operations {
+		if err := operation.validate(fileType); err != nil {
+			return recipe.errorAt(operation.Line, err)
+		}
+	}
+
+	if err := recipe.Output.validate(fileType); err != nil {
+		return recipe.errorAt(recipe.lines["output"], err)
+	}
+	return nil
+}
+
+func (operation Operation) validate(fileType string) error {
+	if operation.Op == "" {
+		return errors.New(`operation is missing "op"`)
+	}
+	spec, ok := operations[operation.Op]
+	if !ok {
+		return fmt.Errorf("unknown operation %q, supported operations are %s", operation.Op, strings.Join(SupportedOperations(), ", "))
+	}
+	if (fileType == "video" && !spec.video) || (fileType == "audio" && !spec.audio) {
+		return fmt.Errorf("%s cannot be applied to %s", operation.Op, fileType)
+	}
+
+	for name := range operation.Params {
+		if !spec.accepts(name) {
+			return fmt.Errorf("%s does not take %q", operation.Op, name)
+		}
+	}
+	for _, param := range spec.params {
+		value, ok := operation.Params[param.name]
+		if !ok {
+			if param.required {
+				return fmt.Errorf("%s needs %q", operation.Op, param.name)
+			}
+			continue
+		}
+		if err := checkParam(param, value); err != nil {
+			return fmt.Errorf("%s: %s", operation.Op, err)
+		}
+	}
+
+	switch operation.Op {
+	case "resize":
+		if operation.integer("width") <= 0 && operation.integer("height") <= 0 {
+			return errors.New("resize needs a positive width or height")
+		}
+	case "blur":
+		if intensity := operation.integer("intensity"); intensity < 0 || intensity > 50 {
+			return errors.New("blur intensity should be between 0 and 50")
+		}
+	case "trim":
+		start, startErr := operation.duration("start", 0)
+		end, endErr := operation.duration("end", 0)
+		if startErr == nil && endErr == nil && start > end {
+			return errors.New("trim start cannot be after its end")
+		}
+	case "text":
+		if operation.text("text") == "" {
+			return errors.New("text cannot be empty")
+		}
+		if position := operation.text("position"); position != "" && !containsString(textPositions, position) {
+			return fmt.Errorf("unknown text position %q, supported positions are %s", position, strings.Join(textPositions, ", "))
+		}
+	case "subtitles":
+		if extension := strings.ToLower(filepath.Ext(operation.text("file"))); !containsString(subtitleExtensions, extension) {
+			return fmt.Errorf("subtitles must be %s files", strings.Join(subtitleExtensions, ", "))
+		}
+		if position := operation.text("position"); position != "" && !containsString(textPositions, position) {
+			return fmt.Errorf("unknown subtitle position %q, supported positions are %s", position, strings.Join(textPositions, ", "))
+		}
+	}
+	return nil
+}
+
+func (spec operationSpec) accepts(name string) bool {
+	for _, param := range spec.params {
+		if param.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func checkParam(param param, value interface{}) error {
+	switch param.kind {
+	case number, integer:
+		number, ok := toFloat(value)
+		if !ok {
+			return fmt.Errorf("%q must be a number", param.name)
+		}
+		if err := checkNumber(param, number); err != nil {
+			return err
+		}
+	case timecode:
+		if seconds, ok := toFloat(value); ok {
+			return checkNumber(param, seconds)
+		}
+		code, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%q must be a number of seconds or a timecode", param.name)
+		}
+		// frame numbers need the frame rate of the input and are checked once it is loaded
+		if strings.HasPrefix(strings.TrimSpace(code), "f") {
+			return nil
+		}
+		if _, err := animax.ParseTimecode(code, 0); err != nil {
+			return fmt.Errorf("%q: %s", param.name, err)
+		}
+	case text, path:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%q must be a string", param.name)
+		}
+	case textList, pathList:
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("%q must be a non-empty list of strings", param.name)
+		}
+		for _, item := range list {
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("%q must be a non-empty list of strings", param.name)
+			}
+		}
+	case boolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%q must be true or false", param.name)
+		}
+	}
+	return nil
+}
+
+// checkNumber rejects the numbers no operation takes: infinities, NaN, negative values and integers with a fraction.
+func checkNumber(param param, value float64) error {
+	switch {
+	case math.IsNaN(value) || math.IsInf(value, 0):
+		return fmt.Errorf("%q must be a finite number", param.name)
+	case value < 0:
+		return fmt.Errorf("%q cannot be negative", param.name)
+	case param.kind == integer && value != math.Trunc(value):
+		return fmt.Errorf("%q must be a whole number", param.name)
+	case param.kind == integer && value > math.MaxInt32:
+		return fmt.Errorf("%q is too large", param.name)
+	}
+	return nil
+}
+
+func (output Output) validate(fileType string) error {
+	if output.Path == "" {
+		return errors.New("output path is required")
+	}
+	if animax.FileType(output.Path) != fileType {
+		return fmt.Errorf("output %s must be a %s file like the input", output.Path, fileType)
+	}
+	if fileType == "audio" {
+		if output.Encoding != "" || output.CRF != 0 || output.Preset != "" || output.Bitrate != "" || output.TwoPass {
+			return errors.New("audio outputs only take audio_bitrate")
+		}
+		return nil
+	}
+	return output.encodeOptions().Validate(output.Path)
+}
+
+// encodeOptions starts from the defaults of the encoding and applies the overrides of the output.
+func (output Output) encodeOptions() animax.EncodeOptions {
+	encoding := output.Encoding
+	switch strings.ToLower(encoding) {
+	case "best":
+		encoding = animax.VIDEO_ENCODINGS.Best
+	case "efficient":
+		encoding = animax.VIDEO_ENCODINGS.Efficient
+	case "compressed":
+		encoding = animax.VIDEO_ENCODINGS.Compressed
+	}
+
+	options := animax.DefaultEncodeOptions(encoding)
+	if output.CRF > 0 {options.CRF = output.CRF}
+	if output.Preset != "" {options.Preset = output.Preset}
+	if output.Bitrate != "" {options.Bitrate = output.Bitrate}
+	if output.AudioBitrate != "" {options.AudioBitrate = output.AudioBitrate}
+	options.TwoPass = output.TwoPass
+	return options
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func (operation Operation) number(name string) float64 {
+	value, _ := toFloat(operation.Params[name])
+	return value
+}
+
+func (operation Operation) integer(name string) int64 {
+	return int64(operation.number(name))
+}
+
+func (operation Operation) text(name string) string {
+	value, _ := operation.Params[name].(string)
+	return value
+}
+
+func (operation Operation) textList(name string) []string {
+	values := []string{}
+	list, _ := operation.Params[name].([]interface{})
+	for _, item := range list {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (operation Operation) boolean(name string, fallback bool) bool {
+	if value, ok := operation.Params[name].(bool); ok {
+		return value
+	}
+	return fallback
+}
+
+// duration reads a time parameter; fps is only needed for frame numbers such as "f120".
+func (operation Operation) duration(name string, fps float64) (time.Duration, error) {
+	value, ok := operation.Params[name]
+	if !ok {
+		return 0, nil
+	}
+	if seconds, ok := toFloat(value); ok {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	code, _ := value.(string)
+	return animax.ParseTimecode(code, fps)
+}
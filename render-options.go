@@ -0,0 +1,85 @@
+package animax
+
+import (
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+type renderSettings struct {
+	progress ProgressFunc
+	executor Executor
+	encode   *EncodeOptions
+	store    JobStore
+	jobID    string
+	parent   string // directory the working dir is created in
+}
+
+// RenderOption configures a single call to RenderContext.
+type RenderOption func(settings *renderSettings)
+
+func newRenderSettings(options []RenderOption) renderSettings {
+	settings := renderSettings{executor: DefaultExecutor}
+	for _, option := range options {
+		option(&settings)
+	}
+	return settings
+}
+
+// WithProgress reports the progress of every ffmpeg stage to fn while the render runs.
+func WithProgress(fn ProgressFunc) RenderOption {
+	return func(settings *renderSettings) {
+		settings.progress = fn
+	}
+}
+
+// WithExecutor runs the ffmpeg stages of a render through executor instead of DefaultExecutor.
+func WithExecutor(executor Executor) RenderOption {
+	return func(settings *renderSettings) {
+		settings.executor = executor
+	}
+}
+
+// WithEncodeOptions encodes the final stage with options instead of the defaults of the requested encoding.
+func WithEncodeOptions(options EncodeOptions) RenderOption {
+	return func(settings *renderSettings) {
+		settings.encode = &options
+	}
+}
+
+/*
+	WithJobStore records the stages of the render in store under jobID as they complete. When the render fails or the process
+	stops, the intermediate files are kept, and rendering the same effects again with the same store and jobID resumes after
+	the last completed stage, until ReleaseJob removes them. Every effect is collapsed into one ffmpeg pass, so most plans
+	have a single stage and start over; only plans of several stages, such as two-pass encodes, skip work.
+*/
+func WithJobStore(store JobStore, jobID string) RenderOption {
+	return func(settings *renderSettings) {
+		settings.store = store
+		settings.jobID = jobID
+	}
+}
+
+/*
+	WithWorkingDir creates the working dir of the render, which holds its intermediate files, inside parent instead of the
+	current directory. Resumable renders should use it, so a restarted process finds their files wherever it runs from.
+*/
+func WithWorkingDir(parent string) RenderOption {
+	return func(settings *renderSettings) {
+		settings.parent = parent
+	}
+}
+
+// workingDir returns the working dir of a previous attempt of the job, or a new one. New ones are absolute so they can be stored.
+func (settings renderSettings) workingDir() string {
+	if settings.store != nil {
+		if record, err := settings.store.Load(settings.jobID); err == nil && record.WorkingDir != "" {
+			return record.WorkingDir
+		}
+	}
+	dir := filepath.Join(settings.parent, uuid.New().String())
+	if absolute, err := filepath.Abs(dir); err == nil {
+		return absolute
+	}
+	return dir
+}
@@ -0,0 +1,189 @@
+package animax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+type ExtractMode int
+
+const (
+	// ExtractSingleCommand decodes the source once and writes every section from a single ffmpeg invocation.
+	ExtractSingleCommand ExtractMode = iota
+	// ExtractParallel runs one ffmpeg invocation per section on a bounded pool of workers.
+	ExtractParallel
+)
+
+type ExtractOptions struct {
+	Mode    ExtractMode
+	Workers int // ExtractParallel only, defaults to the number of CPUs
+
+	// StreamCopy cuts without re-encoding (ExtractParallel only). Sections then start at the keyframe before their start time.
+	StreamCopy bool
+	// Encode is used when re-encoding, defaults to DefaultEncodeOptions(VIDEO_ENCODINGS.Best).
+	Encode *EncodeOptions
+}
+
+// SectionResult is the outcome of extracting a single section. Video is only set when Err is nil.
+type SectionResult struct {
+	Section TrimSection
+	Video   Video
+	Err     error
+}
+
+/*
+	Extracts every section into its OutputName. Results are returned in the order of sections,
+	each with the loaded output video or the error that prevented it from being extracted.
+	The source is not probed again; outputs are probed once they are written.
+*/
+func (video Video) ExtractSections(sections []TrimSection, options ExtractOptions) []SectionResult {
+	return video.ExtractSectionsContext(context.Background(), sections, options)
+}
+
+func (video Video) ExtractSectionsContext(ctx context.Context, sections []TrimSection, options ExtractOptions) []SectionResult {
+	results := make([]SectionResult, len(sections))
+	valid := []int{}
+	for i, section := range sections {
+		results[i].Section = section
+		start, end := section.Range()
+		switch {
+		case section.OutputName == "":
+			results[i].Err = errors.New("section has no output name")
+		case start < 0 || start >= end:
+			results[i].Err = fmt.Errorf("invalid section %s - %s", FormatTimecode(start), FormatTimecode(end))
+		default:
+			valid = append(valid, i)
+		}
+	}
+
+	encode := DefaultEncodeOptions(VIDEO_ENCODINGS.Best)
+	if options.Encode != nil {
+		encode = *options.Encode
+	}
+
+	if options.Mode == ExtractParallel {
+		video.extractParallel(ctx, results, valid, options, encode)
+	} else if len(valid) > 0 {
+		video.extractSingleCommand(ctx, results, valid, encode)
+	}
+	return results
+}
+
+func (video Video) hasAudio() bool {
+	info := video.Info
+	if info == nil {
+		var err error
+		info, err = Probe(video.FilePath)
+		if err != nil {
+			return false
+		}
+	}
+	return info.AudioStream() != nil
+}
+
+func (video Video) extractSingleCommand(ctx context.Context, results []SectionResult, valid []int, encode EncodeOptions) {
+	withAudio := video.hasAudio()
+	count := len(valid)
+
+	graph := []string{}
+	videoSplits := ""
+	audioSplits := ""
+	for n := range valid {
+		videoSplits += fmt.Sprintf("[v%d]", n)
+		audioSplits += fmt.Sprintf("[a%d]", n)
+	}
+	graph = append(graph, fmt.Sprintf("[0:v]split=%d%s", count, videoSplits))
+	if withAudio {
+		graph = append(graph, fmt.Sprintf("[0:a]asplit=%d%s", count, audioSplits))
+	}
+
+	outputs := []string{}
+	for n, i := range valid {
+		start, end := results[i].Section.Range()
+		graph = append(graph, fmt.Sprintf("[v%d]trim=start=%f:end=%f,setpts=PTS-STARTPTS[vo%d]", n, start.Seconds(), end.Seconds(), n))
+		outputs = append(outputs, "-map", fmt.Sprintf("[vo%d]", n))
+		if withAudio {
+			graph = append(graph, fmt.Sprintf("[a%d]atrim=start=%f:end=%f,asetpts=PTS-STARTPTS[ao%d]", n, start.Seconds(), end.Seconds(), n))
+			outputs = append(outputs, "-map", fmt.Sprintf("[ao%d]", n))
+		}
+		outputs = append(outputs, encode.args(video.GetType())...)
+		outputs = append(outputs, "-y", results[i].Section.OutputName)
+	}
+
+	args := append([]string{"-i", video.FilePath, "-filter_complex", strings.Join(graph, ";")}, outputs...)
+	Logger.Infoln("Command to be executed: ffmpeg " + strings.Join(args, " "))
+	_, stderr, err := DefaultExecutor.Run(ctx, "ffmpeg", args)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else {
+			err = newRenderError(0, append([]string{"ffmpeg"}, args...), string(stderr), err)
+		}
+		for _, i := range valid {
+			results[i].Err = err
+		}
+		return
+	}
+
+	for _, i := range valid {
+		results[i].Video, results[i].Err = LoadVideo(results[i].Section.OutputName)
+	}
+}
+
+func (video Video) extractParallel(ctx context.Context, results []SectionResult, valid []int, options ExtractOptions, encode EncodeOptions) {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].Video, results[i].Err = video.extractSection(ctx, results[i].Section, options.StreamCopy, encode)
+			}
+		}()
+	}
+
+	for _, i := range valid {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (video Video) extractSection(ctx context.Context, section TrimSection, streamCopy bool, encode EncodeOptions) (Video, error) {
+	if err := ctx.Err(); err != nil {
+		return Video{}, err
+	}
+
+	start, end := section.Range()
+	var args []string
+	if streamCopy {
+		if keyframe, err := video.KeyframeBeforeContext(ctx, start); err == nil {
+			start = keyframe
+		}
+		args = []string{"-ss", fmt.Sprintf("%.5f", start.Seconds()), "-i", video.FilePath, "-t", fmt.Sprintf("%.5f", (end - start).Seconds()), "-c", "copy"}
+	} else {
+		args = []string{"-ss", fmt.Sprintf("%.5f", start.Seconds()), "-i", video.FilePath, "-t", fmt.Sprintf("%.5f", (end - start).Seconds())}
+		args = append(args, encode.args(video.GetType())...)
+	}
+	args = append(args, "-y", section.OutputName)
+
+	Logger.Infoln("Command to be executed: ffmpeg " + strings.Join(args, " "))
+	_, stderr, err := DefaultExecutor.Run(ctx, "ffmpeg", args)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Video{}, ctx.Err()
+		}
+		return Video{}, newRenderError(0, append([]string{"ffmpeg"}, args...), string(stderr), err)
+	}
+	return LoadVideo(section.OutputName)
+}
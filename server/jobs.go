@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pichan321/animax"
+)
+
+// the statuses are the states of animax.JobRecord so they can be stored as is
+const (
+	StatusQueued    = animax.JobQueued
+	StatusRunning   = animax.JobRunning
+	StatusDone      = animax.JobDone
+	StatusFailed    = animax.JobFailed
+	StatusCancelled = animax.JobCancelled
+)
+
+// JobStatus is the state of a job as reported by the API.
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Stage      int        `json:"stage"`
+	Stages     int        `json:"stages"`
+	Progress   float64    `json:"progress"` // percent of the whole render, every stage included
+	Error      string     `json:"error,omitempty"`
+	Attempts   int        `json:"attempts,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// job is the server side of a submitted render. Every change wakes up the log streams waiting on it.
+type job struct {
+	mu      sync.Mutex
+	status  JobStatus
+	output  string
+	workDir string
+	logs    []string
+	changed chan struct{}
+}
+
+func newJob(id string, workDir string) *job {
+	return &job{
+		status:  JobStatus{ID: id, Status: StatusQueued, CreatedAt: time.Now()},
+		workDir: workDir,
+		changed: make(chan struct{}),
+	}
+}
+
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+func (j *job) finished() bool {
+	switch j.snapshot().Status {
+	case StatusDone, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// update applies change under the lock, records line in the logs when it is not empty and wakes up the waiters.
+func (j *job) update(line string, change func(status *JobStatus)) {
+	j.mu.Lock()
+	if change != nil {
+		change(&j.status)
+	}
+	if line != "" {
+		j.logs = append(j.logs, fmt.Sprintf("%s %s", time.Now().Format("2006-01-02 15:04:05"), line))
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+}
+
+// logsFrom returns the log lines from index on and a channel closed on the next change.
+func (j *job) logsFrom(index int) ([]string, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if index > len(j.logs) {
+		index = len(j.logs)
+	}
+	return append([]string{}, j.logs[index:]...), j.changed
+}
+
+func (j *job) progress(progress animax.Progress) {
+	j.update("", func(status *JobStatus) {
+		if status.Status == StatusQueued {
+			status.Status = StatusRunning
+		}
+		status.Stage = progress.Stage
+		status.Stages = progress.Stages
+		status.Progress = progress.Percent
+	})
+	if progress.Done {
+		j.update(fmt.Sprintf("stage %d/%d done", progress.Stage+1, progress.Stages), nil)
+	}
+}
+
+func (j *job) finish(result animax.JobResult, cancelled bool) {
+	now := time.Now()
+	switch {
+	case result.Err == nil:
+		j.update("render done", func(status *JobStatus) {
+			status.Status = StatusDone
+			status.Progress = 100
+			status.Attempts = result.Attempts
+			status.FinishedAt = &now
+		})
+	case cancelled:
+		j.update("render cancelled", func(status *JobStatus) {
+			status.Status = StatusCancelled
+			status.Error = result.Err.Error()
+			status.Attempts = result.Attempts
+			status.FinishedAt = &now
+		})
+	default:
+		j.update("render failed: "+result.Err.Error(), func(status *JobStatus) {
+			status.Status = StatusFailed
+			status.Error = result.Err.Error()
+			status.Attempts = result.Attempts
+			status.FinishedAt = &now
+		})
+	}
+}
@@ -0,0 +1,482 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pichan321/animax"
+	"github.com/pichan321/animax/recipe"
+)
+
+type Options struct {
+	DataDir       string // uploads and outputs are kept in one directory per job
+	Workers       int
+	Retry         animax.RetryPolicy
+	MaxUploadSize int64  // bytes, defaults to 2 GB
+	SourceRoot    string // when set, source paths must be inside this directory
+
+	// Store keeps the jobs across restarts. Unfinished jobs are queued again by New and resume from their last completed stage,
+	// the files kept for failed and cancelled jobs are removed once they end.
+	Store animax.JobStore
+}
+
+/*
+	Server exposes renders over HTTP:
+
+		POST   /jobs              submit a job, JSON or multipart with a "file" part and a "job" field
+		GET    /jobs              list the jobs
+		GET    /jobs/{id}         status and progress
+		GET    /jobs/{id}/logs    stream the log lines until the job is over
+		GET    /jobs/{id}/output  download the output
+		DELETE /jobs/{id}         cancel the job
+
+	Renders run on an animax.RenderQueue and every ffmpeg and ffprobe call goes through animax.DefaultExecutor.
+*/
+type Server struct {
+	options Options
+	queue   *animax.RenderQueue
+	mux     *http.ServeMux
+
+	mu        sync.Mutex
+	jobs      map[string]*job
+	cancelled map[string]bool // jobs a client cancelled, until their result is collected
+	done      chan struct{}
+}
+
+// JobRequest is the body of POST /jobs. Effects use the operations of recipes, e.g. {"op": "trim", "start": 10, "end": 20}.
+type JobRequest struct {
+	Source   string                   `json:"source"`
+	Effects  []map[string]interface{} `json:"effects"`
+	Output   recipe.Output            `json:"output"` // the path is chosen by the server, only its extension is used
+	Priority int                      `json:"priority"`
+}
+
+func New(options Options) (*Server, error) {
+	if options.DataDir == "" {
+		options.DataDir = "animax-jobs"
+	}
+	if options.MaxUploadSize <= 0 {
+		options.MaxUploadSize = 2 << 30
+	}
+	if err := os.MkdirAll(options.DataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		options:   options,
+		mux:       http.NewServeMux(),
+		jobs:      make(map[string]*job),
+		cancelled: make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	server.queue = animax.NewRenderQueue(animax.QueueOptions{Workers: options.Workers, Retry: options.Retry, OnStart: server.started})
+	server.mux.HandleFunc("/jobs", server.handleJobs)
+	server.mux.HandleFunc("/jobs/", server.handleJob)
+	go server.collect()
+	if err := server.restore(); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// Close stops accepting jobs and waits for the queued and running ones to finish.
+func (server *Server) Close() {
+	server.queue.Close()
+	<-server.done
+}
+
+func (server *Server) started(renderJob animax.RenderJob) {
+	server.mu.Lock()
+	job, ok := server.jobs[renderJob.ID]
+	server.mu.Unlock()
+	if ok {
+		job.update("render started", func(status *JobStatus) { status.Status = StatusRunning })
+		server.record(renderJob.ID, func(record *animax.JobRecord) { record.State = animax.JobRunning })
+	}
+}
+
+// collect moves the results of the queue into the jobs.
+func (server *Server) collect() {
+	defer close(server.done)
+	for result := range server.queue.Results() {
+		// finished under the lock so cancel sees either a running job or its final status
+		server.mu.Lock()
+		job, ok := server.jobs[result.Job.ID]
+		cancelled := server.cancelled[result.Job.ID]
+		delete(server.cancelled, result.Job.ID)
+		if ok {
+			job.finish(result, cancelled || errors.Is(result.Err, context.Canceled))
+		}
+		server.mu.Unlock()
+		if !ok {
+			continue
+		}
+		status := job.snapshot()
+		server.record(status.ID, func(record *animax.JobRecord) { record.State, record.Error = status.Status, status.Error })
+		if server.options.Store != nil && status.Status != StatusDone {
+			if err := animax.ReleaseJob(server.options.Store, status.ID); err != nil {
+				animax.Logger.Warnf("Unable to remove the files of job %s | Error: %s", status.ID, err)
+			}
+		}
+	}
+}
+
+func (server *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		server.submit(w, r)
+	case http.MethodGet:
+		server.mu.Lock()
+		statuses := []JobStatus{}
+		for _, job := range server.jobs {
+			statuses = append(statuses, job.snapshot())
+		}
+		server.mu.Unlock()
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].CreatedAt.Before(statuses[j].CreatedAt) })
+		writeJSON(w, http.StatusOK, statuses)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (server *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+	server.mu.Lock()
+	job, ok := server.jobs[parts[0]]
+	server.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, job.snapshot())
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		server.cancel(w, job)
+	case len(parts) == 2 && parts[1] == "logs" && r.Method == http.MethodGet:
+		streamLogs(w, r, job)
+	case len(parts) == 2 && parts[1] == "output" && r.Method == http.MethodGet:
+		if status := job.snapshot(); status.Status != StatusDone {
+			writeError(w, http.StatusConflict, fmt.Errorf("job %s is %s", status.ID, status.Status))
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(job.output)))
+		http.ServeFile(w, r, job.output)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (server *Server) submit(w http.ResponseWriter, r *http.Request) {
+	id := uuid.New().String()
+	workDir := filepath.Join(server.options.DataDir, id)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	request, err := server.decodeRequest(r, workDir)
+	if err != nil {
+		os.RemoveAll(workDir)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	extension := filepath.Ext(request.Output.Path)
+	if extension == "" {
+		extension = filepath.Ext(request.Source)
+	}
+	request.Output.Path = filepath.Join(workDir, "output"+extension)
+
+	job := newJob(id, workDir)
+	if err := server.enqueue(job, request); err != nil {
+		os.RemoveAll(workDir)
+		if server.options.Store != nil {
+			server.options.Store.Delete(id)
+		}
+		code := http.StatusBadRequest
+		if errors.Is(err, animax.ErrQueueClosed) {
+			code = http.StatusServiceUnavailable
+		}
+		writeError(w, code, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+// enqueue builds the render of request and submits it for job, recording the job in the store when there is one.
+func (server *Server) enqueue(job *job, request JobRequest) error {
+	operations := []recipe.Operation{}
+	for _, effect := range request.Effects {
+		operations = append(operations, recipe.Operation{Params: effect})
+	}
+	edit, err := recipe.New("job", request.Source, operations, request.Output)
+	if err != nil {
+		return err
+	}
+	// fonts and other files read by the effects are held to the same root as the source
+	for _, file := range edit.Files() {
+		if err := server.checkSource(file); err != nil {
+			return err
+		}
+	}
+	renderJob, err := edit.Job()
+	if err != nil {
+		return err
+	}
+
+	id := job.status.ID
+	job.output = request.Output.Path
+	renderJob.ID = id
+	renderJob.Priority = request.Priority
+	renderJob.Options = append(renderJob.Options, animax.WithProgress(job.progress))
+	if server.options.Store != nil {
+		spec, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		err = server.options.Store.Update(id, func(record *animax.JobRecord) {
+			record.Spec, record.State, record.CreatedAt = spec, animax.JobQueued, job.status.CreatedAt
+		})
+		if err != nil {
+			return err
+		}
+		// the intermediate files stay with the job, so a restart finds them whatever its working directory
+		renderJob.Options = append(renderJob.Options, animax.WithJobStore(server.options.Store, id), animax.WithWorkingDir(job.workDir))
+	}
+
+	server.mu.Lock()
+	server.jobs[id] = job
+	server.mu.Unlock()
+	job.update(fmt.Sprintf("queued %s with %d effects", request.Source, len(operations)), nil)
+
+	if _, err := server.queue.Submit(renderJob); err != nil {
+		server.mu.Lock()
+		delete(server.jobs, id)
+		server.mu.Unlock()
+		server.record(id, func(record *animax.JobRecord) { record.State, record.Error = animax.JobFailed, err.Error() })
+		return err
+	}
+	return nil
+}
+
+// restore loads the jobs of the store, queueing the unfinished ones again.
+func (server *Server) restore() error {
+	if server.options.Store == nil {
+		return nil
+	}
+	records, err := server.options.Store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		job := newJob(record.ID, filepath.Join(server.options.DataDir, record.ID))
+		job.status.CreatedAt = record.CreatedAt
+
+		var request JobRequest
+		if err := json.Unmarshal(record.Spec, &request); err != nil {
+			animax.Logger.Warnf("Skipping job %s | Error: %s", record.ID, err)
+			continue
+		}
+		if !record.Finished() {
+			if err := server.enqueue(job, request); err != nil {
+				// the job cannot run again, e.g. its source is gone, so it is failed instead of retried on every start
+				animax.Logger.Errorf("Unable to resume job %s | Error: %s", record.ID, err)
+				server.abandon(job, err)
+			} else {
+				job.update(fmt.Sprintf("resumed after restart, %d stages already done", record.CompletedStages), nil)
+			}
+			continue
+		}
+
+		finishedAt := record.UpdatedAt
+		job.output = request.Output.Path
+		job.status.Status, job.status.Error, job.status.FinishedAt = record.State, record.Error, &finishedAt
+		if record.State == animax.JobDone {
+			job.status.Progress = 100
+		}
+		server.mu.Lock()
+		server.jobs[record.ID] = job
+		server.mu.Unlock()
+	}
+	return nil
+}
+
+// abandon fails a job that could not be queued again, releases its files and keeps it listed.
+func (server *Server) abandon(job *job, err error) {
+	now := time.Now()
+	job.update("unable to resume: "+err.Error(), func(status *JobStatus) {
+		status.Status, status.Error, status.FinishedAt = StatusFailed, err.Error(), &now
+	})
+	id := job.snapshot().ID
+	server.record(id, func(record *animax.JobRecord) { record.State, record.Error = animax.JobFailed, err.Error() })
+	if err := animax.ReleaseJob(server.options.Store, id); err != nil {
+		animax.Logger.Warnf("Unable to remove the files of job %s | Error: %s", id, err)
+	}
+	server.mu.Lock()
+	server.jobs[id] = job
+	server.mu.Unlock()
+}
+
+// record applies change to the stored record of the job, when the server has a store.
+func (server *Server) record(id string, change func(record *animax.JobRecord)) {
+	if server.options.Store == nil {
+		return
+	}
+	if err := server.options.Store.Update(id, change); err != nil {
+		animax.Logger.Warnf("Unable to record job %s | Error: %s", id, err)
+	}
+}
+
+// decodeRequest reads a JSON body, or a multipart form whose "file" part is saved in workDir and used as the source.
+func (server *Server) decodeRequest(r *http.Request, workDir string) (JobRequest, error) {
+	var request JobRequest
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request); err != nil {
+			return request, fmt.Errorf("invalid job: %w", err)
+		}
+		return request, server.checkSource(request.Source)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return request, err
+	}
+	uploaded := ""
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return request, err
+		}
+
+		switch part.FormName() {
+		case "job":
+			if err := json.NewDecoder(io.LimitReader(part, 1<<20)).Decode(&request); err != nil {
+				return request, fmt.Errorf("invalid job: %w", err)
+			}
+		case "file":
+			source := filepath.Join(workDir, "source"+filepath.Ext(part.FileName()))
+			if err := saveUpload(part, source, server.options.MaxUploadSize); err != nil {
+				return request, err
+			}
+			uploaded = source
+		}
+	}
+	if uploaded == "" {
+		return request, server.checkSource(request.Source)
+	}
+	request.Source = uploaded
+	return request, nil
+}
+
+func saveUpload(part io.Reader, path string, maxSize int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(part, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if written > maxSize {
+		return fmt.Errorf("upload is bigger than %d bytes", maxSize)
+	}
+	return nil
+}
+
+func (server *Server) checkSource(source string) error {
+	if source == "" {
+		return errors.New("source is required")
+	}
+	if server.options.SourceRoot == "" {
+		return nil
+	}
+	root, err := filepath.Abs(server.options.SourceRoot)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+	if relative, err := filepath.Rel(root, path); err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("source %s is outside of %s", source, server.options.SourceRoot)
+	}
+	return nil
+}
+
+func (server *Server) cancel(w http.ResponseWriter, job *job) {
+	server.mu.Lock()
+	status := job.snapshot()
+	if job.finished() {
+		server.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s is already %s", status.ID, status.Status))
+		return
+	}
+	server.cancelled[status.ID] = true
+	server.mu.Unlock()
+	server.queue.Cancel(status.ID)
+	job.update("cancel requested", nil)
+	writeJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+// streamLogs writes the log lines of job as they come, until the job is over or the client goes away.
+func streamLogs(w http.ResponseWriter, r *http.Request, job *job) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+
+	index := 0
+	for {
+		finished := job.finished()
+		lines, changed := job.logsFrom(index)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		index += len(lines)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,305 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pichan321/animax"
+	"github.com/pichan321/animax/recipe"
+	"github.com/pichan321/animax/server"
+)
+
+const probeOutput = `{"streams": [{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "r_frame_rate": "30/1", "avg_frame_rate": "30/1", "nb_frames": "3600"}, {"index": 1, "codec_type": "audio", "codec_name": "aac"}], "format": {"duration": "120.0"}}`
+
+/*
+	newServer starts a server whose ffprobe calls describe a 2 minute 1080p video and whose ffmpeg calls are answered by
+	render. Sources are created under the SourceRoot of the server, which is returned with it.
+*/
+func newServer(t *testing.T, options server.Options, render func(cmd animax.Command) animax.Response) (*server.Server, *animax.RecordingExecutor, string) {
+	t.Helper()
+	recorder := &animax.RecordingExecutor{
+		CreateOutputs: true,
+		Handler: func(cmd animax.Command) animax.Response {
+			if cmd.Name == "ffprobe" {
+				return animax.Response{Stdout: []byte(probeOutput)}
+			}
+			return render(cmd)
+		},
+	}
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+
+	root := t.TempDir()
+	options.SourceRoot = root
+	if options.DataDir == "" {options.DataDir = filepath.Join(t.TempDir(), "jobs")}
+	srv, err := server.New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		animax.DefaultExecutor = executor
+	})
+	return srv, recorder, root
+}
+
+func source(t *testing.T, root string, name string) string {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func request(t *testing.T, srv *server.Server, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response := httptest.NewRecorder()
+	srv.ServeHTTP(response, httptest.NewRequest(method, path, &reader))
+	return response
+}
+
+func submit(t *testing.T, srv *server.Server, job server.JobRequest) server.JobStatus {
+	t.Helper()
+	response := request(t, srv, http.MethodPost, "/jobs", job)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d %s, want %d", response.Code, response.Body, http.StatusAccepted)
+	}
+	var status server.JobStatus
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// wait polls the job until it is over.
+func wait(t *testing.T, srv *server.Server, id string) server.JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var status server.JobStatus
+		response := request(t, srv, http.MethodGet, "/jobs/"+id, nil)
+		if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.FinishedAt != nil {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return server.JobStatus{}
+}
+
+func ffmpegCalls(recorder *animax.RecordingExecutor) []animax.Command {
+	calls := []animax.Command{}
+	for _, call := range recorder.Calls() {
+		if call.Name == "ffmpeg" {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func TestSubmitRendersJob(t *testing.T) {
+	srv, recorder, root := newServer(t, server.Options{}, func(cmd animax.Command) animax.Response {
+		return animax.Response{Stdout: []byte("out_time_us=5000000\nprogress=end\n")}
+	})
+	input := source(t, root, "input.mp4")
+
+	status := submit(t, srv, server.JobRequest{
+		Source:  input,
+		Effects: []map[string]interface{}{{"op": "trim", "start": 10, "end": 20}, {"op": "volume", "multiplier": 2}},
+		Output:  recipe.Output{Path: "output.mp4"},
+	})
+	status = wait(t, srv, status.ID)
+	if status.Status != server.StatusDone || status.Progress != 100 {
+		t.Fatalf("job = %s at %.0f%%, want done at 100%%: %s", status.Status, status.Progress, status.Error)
+	}
+
+	calls := ffmpegCalls(recorder)
+	if len(calls) != 1 {
+		t.Fatalf("ran %d ffmpeg commands, want 1", len(calls))
+	}
+	args := strings.Join(calls[0].Args, " ")
+	for _, want := range []string{"-ss 10.000000 -to 20.000000 -i " + input, "[0:a]volume=2.000000[a]"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("ffmpeg %s\nwant %q", args, want)
+		}
+	}
+
+	response := request(t, srv, http.MethodGet, "/jobs/"+status.ID+"/output", nil)
+	if response.Code != http.StatusOK {
+		t.Errorf("GET output = %d, want %d", response.Code, http.StatusOK)
+	}
+}
+
+func TestSubmitRejectsInvalidJobs(t *testing.T) {
+	srv, recorder, root := newServer(t, server.Options{}, func(cmd animax.Command) animax.Response {
+		return animax.Response{}
+	})
+	input := source(t, root, "input.mp4")
+	outside := filepath.Join(t.TempDir(), "outside.mp4")
+	os.WriteFile(outside, nil, 0644)
+
+	tests := []struct {
+		name string
+		job  server.JobRequest
+	}{
+		{"unknown operation", server.JobRequest{Source: input, Effects: []map[string]interface{}{{"op": "explode"}}}},
+		{"missing parameter", server.JobRequest{Source: input, Effects: []map[string]interface{}{{"op": "blur"}}}},
+		{"source outside the root", server.JobRequest{Source: outside, Effects: []map[string]interface{}{{"op": "blur", "intensity": 3}}}},
+		{"font outside the root", server.JobRequest{Source: input, Effects: []map[string]interface{}{{"op": "text", "text": "hi", "font": "/etc/passwd"}}}},
+		{"no source", server.JobRequest{Effects: []map[string]interface{}{{"op": "blur", "intensity": 3}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.job.Output = recipe.Output{Path: "output.mp4"}
+			if response := request(t, srv, http.MethodPost, "/jobs", test.job); response.Code != http.StatusBadRequest {
+				t.Errorf("POST /jobs = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+			}
+		})
+	}
+	if calls := ffmpegCalls(recorder); len(calls) != 0 {
+		t.Errorf("ran %d ffmpeg commands for rejected jobs", len(calls))
+	}
+}
+
+func TestUnknownJob(t *testing.T) {
+	srv, _, _ := newServer(t, server.Options{}, func(cmd animax.Command) animax.Response {
+		return animax.Response{}
+	})
+	for _, path := range []string{"/jobs/missing", "/jobs/missing/output"} {
+		if response := request(t, srv, http.MethodGet, path, nil); response.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", path, response.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	release := make(chan struct{})
+	srv, recorder, root := newServer(t, server.Options{Workers: 1}, func(cmd animax.Command) animax.Response {
+		<-release
+		return animax.Response{}
+	})
+	input := source(t, root, "input.mp4")
+	job := server.JobRequest{Source: input, Effects: []map[string]interface{}{{"op": "blur", "intensity": 3}}, Output: recipe.Output{Path: "output.mp4"}}
+
+	running := submit(t, srv, job)
+	queued := submit(t, srv, job)
+	if response := request(t, srv, http.MethodDelete, "/jobs/"+queued.ID, nil); response.Code != http.StatusAccepted {
+		t.Fatalf("DELETE = %d %s, want %d", response.Code, response.Body, http.StatusAccepted)
+	}
+	if status := wait(t, srv, queued.ID); status.Status != server.StatusCancelled {
+		t.Errorf("cancelled job = %s, want %s", status.Status, server.StatusCancelled)
+	}
+
+	close(release)
+	if status := wait(t, srv, running.ID); status.Status != server.StatusDone {
+		t.Errorf("running job = %s, want %s: %s", status.Status, server.StatusDone, status.Error)
+	}
+	if calls := ffmpegCalls(recorder); len(calls) != 1 {
+		t.Errorf("ran %d ffmpeg commands, want only the one of the running job", len(calls))
+	}
+	if response := request(t, srv, http.MethodDelete, "/jobs/"+running.ID, nil); response.Code != http.StatusConflict {
+		t.Errorf("DELETE finished job = %d, want %d", response.Code, http.StatusConflict)
+	}
+}
+
+func TestFailedJobReleasesFiles(t *testing.T) {
+	store, err := animax.NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	workingDir := make(chan string, 1)
+	dataDir := t.TempDir()
+	srv, _, root := newServer(t, server.Options{Store: store, DataDir: dataDir}, func(cmd animax.Command) animax.Response {
+		workingDir <- filepath.Dir(cmd.Args[len(cmd.Args)-1])
+		return animax.Response{Stderr: []byte("Invalid data found when processing input"), Err: errors.New("exit status 1")}
+	})
+	input := source(t, root, "input.mp4")
+
+	status := submit(t, srv, server.JobRequest{Source: input, Effects: []map[string]interface{}{{"op": "blur", "intensity": 3}}, Output: recipe.Output{Path: "output.mp4"}})
+	if status = wait(t, srv, status.ID); status.Status != server.StatusFailed {
+		t.Fatalf("job = %s, want %s", status.Status, server.StatusFailed)
+	}
+	if response := request(t, srv, http.MethodGet, "/jobs/"+status.ID+"/output", nil); response.Code != http.StatusConflict {
+		t.Errorf("GET output of a failed job = %d, want %d", response.Code, http.StatusConflict)
+	}
+
+	// the record is written after the status, give the server the time to release the files
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record, err := store.Load(status.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.State == animax.JobFailed && record.WorkingDir == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("record = %+v, want a failed job without working dir", record)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if dir := <-workingDir; !strings.HasPrefix(dir, dataDir+string(filepath.Separator)) {
+		t.Errorf("working dir %s is not under the data dir %s", dir, dataDir)
+	} else if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("working dir %s was kept after the job failed", dir)
+	}
+}
+
+func TestRestoreFailsJobsThatCannotResume(t *testing.T) {
+	store, err := animax.NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the source of the job was deleted while the service was down
+	spec, _ := json.Marshal(server.JobRequest{
+		Source:  filepath.Join(t.TempDir(), "deleted.mp4"),
+		Effects: []map[string]interface{}{{"op": "blur", "intensity": 3}},
+		Output:  recipe.Output{Path: "output.mp4"},
+	})
+	workingDir := t.TempDir()
+	store.Update("lost", func(record *animax.JobRecord) {
+		record.Spec, record.State, record.WorkingDir, record.CompletedStages = spec, animax.JobRunning, workingDir, 1
+	})
+
+	srv, _, _ := newServer(t, server.Options{Store: store}, func(cmd animax.Command) animax.Response {
+		return animax.Response{}
+	})
+	response := request(t, srv, http.MethodGet, "/jobs/lost", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /jobs/lost = %d, want %d", response.Code, http.StatusOK)
+	}
+	var status server.JobStatus
+	json.Unmarshal(response.Body.Bytes(), &status)
+	if status.Status != server.StatusFailed || status.Error == "" || status.FinishedAt == nil {
+		t.Errorf("status = %+v, want a finished failed job with its error", status)
+	}
+
+	record, err := store.Load("lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.State != animax.JobFailed || record.WorkingDir != "" {
+		t.Errorf("record = %+v, want a failed job without working dir", record)
+	}
+	if _, err := os.Stat(workingDir); !os.IsNotExist(err) {
+		t.Errorf("working dir %s was kept", workingDir)
+	}
+}
@@ -0,0 +1,194 @@
+package animax
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned by a JobStore asked for a job it does not hold.
+var ErrJobNotFound = errors.New("job not found")
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+/*
+	JobRecord is the state of a job kept by a JobStore.
+	Spec is whatever the owner of the job needs to submit it again, e.g. the request of the render service.
+	CompletedStages counts the stages of the render plan whose output is in WorkingDir, PlanHash tells which plan they belong to.
+*/
+type JobRecord struct {
+	ID              string          `json:"id"`
+	Spec            json.RawMessage `json:"spec,omitempty"`
+	State           string          `json:"state"`
+	WorkingDir      string          `json:"working_dir,omitempty"`
+	PlanHash        string          `json:"plan_hash,omitempty"`
+	CompletedStages int             `json:"completed_stages"`
+	Upload          *UploadSession  `json:"upload,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// UploadSession is a chunked upload in progress. StartOffset is the last offset acknowledged by the server.
+type UploadSession struct {
+	SessionID   string `json:"session_id"`
+	FilePath    string `json:"file_path"`
+	FileSize    int64  `json:"file_size"`
+	StartOffset int64  `json:"start_offset"`
+	EndOffset   int64  `json:"end_offset"`
+}
+
+// Finished reports whether the job reached a final state.
+func (record JobRecord) Finished() bool {
+	return record.State == JobDone || record.State == JobFailed || record.State == JobCancelled
+}
+
+/*
+	JobStore keeps job records across restarts. Update creates the record when it does not exist yet,
+	and applies change atomically so a render and its owner can update the same record.
+*/
+type JobStore interface {
+	Load(id string) (JobRecord, error)
+	List() ([]JobRecord, error)
+	Update(id string, change func(record *JobRecord)) error
+	Delete(id string) error
+}
+
+// FileJobStore is a JobStore keeping one JSON file per job in a directory.
+type FileJobStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileJobStore(dir string) (*FileJobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileJobStore{dir: dir}, nil
+}
+
+func (store *FileJobStore) path(id string) string {
+	return filepath.Join(store.dir, filepath.Base(id)+".json")
+}
+
+func (store *FileJobStore) Load(id string) (JobRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.load(id)
+}
+
+func (store *FileJobStore) load(id string) (JobRecord, error) {
+	var record JobRecord
+	data, err := os.ReadFile(store.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return record, ErrJobNotFound
+	}
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// List returns every record, oldest first.
+func (store *FileJobStore) List() ([]JobRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	records := []JobRecord{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		record, err := store.load(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			Logger.Warnf("Skipping job record %s | Error: %s", entry.Name(), err)
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (store *FileJobStore) Update(id string, change func(record *JobRecord)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	record, err := store.load(id)
+	if errors.Is(err, ErrJobNotFound) {
+		record = JobRecord{ID: id, State: JobQueued, CreatedAt: time.Now()}
+	} else if err != nil {
+		return err
+	}
+	change(&record)
+	record.ID = id
+	record.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written next to the record then renamed so a crash never leaves half a record behind
+	temp := store.path(id) + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, store.path(id))
+}
+
+func (store *FileJobStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	err := os.Remove(store.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrJobNotFound
+	}
+	return err
+}
+
+/*
+	ReleaseJob removes the intermediate files kept for the job and forgets its completed stages. Call it once a job ends
+	without being rendered again, e.g. when it failed for good or was cancelled; successful renders release their files.
+*/
+func ReleaseJob(store JobStore, id string) error {
+	record, err := store.Load(id)
+	if err != nil {
+		return err
+	}
+	if record.WorkingDir != "" {
+		if err := os.RemoveAll(record.WorkingDir); err != nil {
+			return err
+		}
+	}
+	return store.Update(id, func(record *JobRecord) {
+		record.WorkingDir, record.PlanHash, record.CompletedStages = "", "", 0
+	})
+}
+
+// hash identifies the commands of a plan, so completed stages are only reused by the plan that produced them.
+func (plan RenderPlan) hash() string {
+	digest := sha256.New()
+	for _, stage := range plan.Stages {
+		digest.Write([]byte(strings.Join(stage.Args, "\x00")))
+		digest.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
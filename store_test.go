@@ -0,0 +1,141 @@
+package animax_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pichan321/animax"
+)
+
+func TestRenderResumesFromTheStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := animax.NewFileJobStore(filepath.Join(dir, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := newRecorder()
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+	defer func() { animax.DefaultExecutor = executor }()
+
+	video, err := animax.LoadVideo(touch(t, dir, "input.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output.mp4")
+	twoPass := animax.WithEncodeOptions(animax.EncodeOptions{Codec: "libx264", Bitrate: "2M", TwoPass: true})
+	options := func(executor animax.Executor) []animax.RenderOption {
+		return []animax.RenderOption{animax.WithExecutor(executor), animax.WithJobStore(store, "job"), animax.WithWorkingDir(dir), twoPass}
+	}
+	render := func(executor animax.Executor) error {
+		_, err := video.Blur(3).RenderContext(context.Background(), output, "", options(executor)...)
+		return err
+	}
+	plan, err := video.Blur(3).Plan(output, "", twoPass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Stages) != 2 {
+		t.Fatalf("planned %d stages, want the 2 passes", len(plan.Stages))
+	}
+
+	// the first pass completes, the second fails
+	passes := 0
+	failing := &animax.RecordingExecutor{
+		CreateOutputs: true,
+		Handler: func(cmd animax.Command) animax.Response {
+			if passes++; passes > 1 {
+				return animax.Response{Stderr: []byte("killed"), Err: errors.New("exit status 137")}
+			}
+			return animax.Response{}
+		},
+	}
+	var renderErr *animax.RenderError
+	if err := render(failing); !errors.As(err, &renderErr) || renderErr.Stage != 1 {
+		t.Fatalf("got %v, want the second stage to fail", err)
+	}
+	record, err := store.Load("job")
+	if err != nil || record.CompletedStages != 1 || record.WorkingDir == "" {
+		t.Fatalf("stored %+v (%v), want 1 completed stage and the working dir", record, err)
+	}
+	if _, err := os.Stat(record.WorkingDir); err != nil {
+		t.Fatalf("the working dir of the failed render is gone: %s", err)
+	}
+
+	// planned again with the store, the stages write to the working dir of the failed render
+	plan, err = video.Blur(3).Plan(output, "", options(recorder)...)
+	if err != nil || plan.WorkingDir != record.WorkingDir {
+		t.Fatalf("planned in %s (%v), want the stored working dir %s", plan.WorkingDir, err, record.WorkingDir)
+	}
+	recorder.Reset()
+	if err := render(recorder); err != nil {
+		t.Fatal(err)
+	}
+	ran := [][]string{}
+	for _, call := range recorder.Calls() {
+		if call.Name == "ffmpeg" {
+			ran = append(ran, call.Args)
+		}
+	}
+	if len(ran) != 1 || !reflect.DeepEqual(ran[0], plan.Stages[1].Args[1:]) {
+		t.Errorf("resumed with\n%q\nwant only the second pass", ran)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("no output after resuming: %s", err)
+	}
+	record, err = store.Load("job")
+	if err != nil || record.WorkingDir != "" || record.PlanHash != "" {
+		t.Errorf("stored %+v (%v), want the working dir released", record, err)
+	}
+	if _, err := os.Stat(plan.WorkingDir); err == nil {
+		t.Error("the working dir was kept after the render")
+	}
+}
+
+func TestRenderStartsOverForAnotherPlan(t *testing.T) {
+	dir := t.TempDir()
+	store, err := animax.NewFileJobStore(filepath.Join(dir, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := newRecorder()
+	executor := animax.DefaultExecutor
+	animax.DefaultExecutor = recorder
+	defer func() { animax.DefaultExecutor = executor }()
+
+	video, err := animax.LoadVideo(touch(t, dir, "input.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a different plan was stopped after its first stage
+	workingDir := filepath.Join(dir, "previous")
+	if err := os.MkdirAll(workingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	store.Update("job", func(record *animax.JobRecord) {
+		record.WorkingDir, record.PlanHash, record.CompletedStages = workingDir, "another plan", 1
+	})
+
+	options := []animax.RenderOption{
+		animax.WithExecutor(recorder), animax.WithJobStore(store, "job"),
+		animax.WithEncodeOptions(animax.EncodeOptions{Codec: "libx264", Bitrate: "2M", TwoPass: true}),
+	}
+	if _, err := video.Blur(3).RenderContext(context.Background(), filepath.Join(dir, "output.mp4"), "", options...); err != nil {
+		t.Fatal(err)
+	}
+	ffmpeg := 0
+	for _, call := range recorder.Calls() {
+		if call.Name == "ffmpeg" {
+			ffmpeg++
+		}
+	}
+	if ffmpeg != 2 {
+		t.Errorf("ran %d stages, want both passes", ffmpeg)
+	}
+}
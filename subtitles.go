@@ -0,0 +1,407 @@
+package animax
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var SUBTITLE_FORMATS = struct {
+	SRT string
+	VTT string
+	ASS string
+}{
+	SRT: ".srt",
+	VTT: ".vtt",
+	ASS: ".ass",
+}
+
+// SubtitleCue is one subtitle shown from Start to End.
+type SubtitleCue struct {
+	Start    time.Duration
+	End      time.Duration
+	Text     string // lines are separated by \n, formatting tags are kept as they are
+	Settings string // WebVTT cue settings, e.g. "align:start line:90%"
+
+	event []string // fields of the ASS Dialogue line, in the order of the Format line
+}
+
+/*
+	Subtitles holds the cues of a subtitle file. Header keeps what comes before the cues, the WEBVTT block and its STYLE
+	and REGION blocks for WebVTT, the sections before [Events] for ASS, so a file written in its own format keeps its styles.
+*/
+type Subtitles struct {
+	Format string // one of SUBTITLE_FORMATS
+	Header string
+	Cues   []SubtitleCue
+
+	fields []string // Format line of the ASS events
+}
+
+var defaultASSHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 384
+PlayResY: 288
+WrapStyle: 0
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,16,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,0,2,10,10,10,1`
+
+var defaultASSFields = []string{"Layer", "Start", "End", "Style", "Name", "MarginL", "MarginR", "MarginV", "Effect", "Text"}
+
+// subtitleFormat returns the format of a subtitle file from its extension, "" when it is not a subtitle file.
+func subtitleFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case SUBTITLE_FORMATS.SRT:
+		return SUBTITLE_FORMATS.SRT
+	case SUBTITLE_FORMATS.VTT:
+		return SUBTITLE_FORMATS.VTT
+	case SUBTITLE_FORMATS.ASS, ".ssa":
+		return SUBTITLE_FORMATS.ASS
+	}
+	return ""
+}
+
+// LoadSubtitles reads an SRT, WebVTT or ASS file. The format is picked by the extension.
+func LoadSubtitles(subtitlesPath string) (Subtitles, error) {
+	format := subtitleFormat(subtitlesPath)
+	if format == "" {
+		Logger.Errorf("subtitlesPath: %s | Subtitle format is not supported", subtitlesPath)
+		return Subtitles{}, fmt.Errorf("subtitlesPath: %s | subtitle format %s is not supported", subtitlesPath, filepath.Ext(subtitlesPath))
+	}
+	data, err := os.ReadFile(subtitlesPath)
+	if err != nil {
+		Logger.Errorf("subtitlesPath: %s | Unable to read subtitles | %s", subtitlesPath, err)
+		return Subtitles{}, err
+	}
+	subtitles, err := ParseSubtitles(string(data), format)
+	if err != nil {
+		return Subtitles{}, fmt.Errorf("subtitlesPath: %s | %w", subtitlesPath, err)
+	}
+	return subtitles, nil
+}
+
+// ParseSubtitles parses the content of a subtitle file in format, one of SUBTITLE_FORMATS.
+func ParseSubtitles(data string, format string) (Subtitles, error) {
+	data = strings.TrimPrefix(data, "\uFEFF")
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\r", "\n")
+	switch format {
+	case SUBTITLE_FORMATS.SRT:
+		return parseSRT(data)
+	case SUBTITLE_FORMATS.VTT:
+		return parseVTT(data)
+	case SUBTITLE_FORMATS.ASS:
+		return parseASS(data)
+	}
+	return Subtitles{}, fmt.Errorf("unknown subtitle format %q", format)
+}
+
+// blocks splits data on blank lines.
+func blocks(data string) [][]string {
+	result := [][]string{}
+	block := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(block) > 0 {result = append(result, block)}
+			block = []string{}
+			continue
+		}
+		block = append(block, line)
+	}
+	if len(block) > 0 {result = append(result, block)}
+	return result
+}
+
+// parseTiming parses a "start --> end settings" line.
+func parseTiming(line string) (start time.Duration, end time.Duration, settings string, err error) {
+	parts := strings.SplitN(line, "-->", 2)
+	if len(parts) != 2 {
+		return 0, 0, "", fmt.Errorf("invalid timing %q", line)
+	}
+	fields := strings.Fields(parts[1])
+	if len(fields) == 0 {
+		return 0, 0, "", fmt.Errorf("invalid timing %q", line)
+	}
+	if start, err = ParseTimecode(strings.ReplaceAll(parts[0], ",", "."), 0); err != nil {
+		return 0, 0, "", err
+	}
+	if end, err = ParseTimecode(strings.ReplaceAll(fields[0], ",", "."), 0); err != nil {
+		return 0, 0, "", err
+	}
+	return start, end, strings.Join(fields[1:], " "), nil
+}
+
+func parseSRT(data string) (Subtitles, error) {
+	subtitles := Subtitles{Format: SUBTITLE_FORMATS.SRT, Cues: []SubtitleCue{}}
+	for _, block := range blocks(data) {
+		// the counter is optional in practice
+		if !strings.Contains(block[0], "-->") {block = block[1:]}
+		if len(block) == 0 {continue}
+		start, end, _, err := parseTiming(block[0])
+		if err != nil {
+			return Subtitles{}, fmt.Errorf("cue %d: %w", len(subtitles.Cues)+1, err)
+		}
+		subtitles.Cues = append(subtitles.Cues, SubtitleCue{Start: start, End: end, Text: strings.Join(block[1:], "\n")})
+	}
+	return subtitles, nil
+}
+
+func parseVTT(data string) (Subtitles, error) {
+	parsed := blocks(data)
+	if len(parsed) == 0 || !strings.HasPrefix(parsed[0][0], "WEBVTT") {
+		return Subtitles{}, errors.New("WebVTT files start with WEBVTT")
+	}
+	header := []string{strings.Join(parsed[0], "\n")}
+	subtitles := Subtitles{Format: SUBTITLE_FORMATS.VTT, Cues: []SubtitleCue{}}
+	for _, block := range parsed[1:] {
+		switch {
+		case strings.HasPrefix(block[0], "NOTE"):
+			continue
+		case strings.HasPrefix(block[0], "STYLE") || strings.HasPrefix(block[0], "REGION"):
+			header = append(header, strings.Join(block, "\n"))
+			continue
+		}
+		// cues may have an identifier
+		if !strings.Contains(block[0], "-->") {block = block[1:]}
+		if len(block) == 0 {continue}
+		start, end, settings, err := parseTiming(block[0])
+		if err != nil {
+			return Subtitles{}, fmt.Errorf("cue %d: %w", len(subtitles.Cues)+1, err)
+		}
+		subtitles.Cues = append(subtitles.Cues, SubtitleCue{Start: start, End: end, Text: strings.Join(block[1:], "\n"), Settings: settings})
+	}
+	subtitles.Header = strings.Join(header, "\n\n")
+	return subtitles, nil
+}
+
+func parseASS(data string) (Subtitles, error) {
+	subtitles := Subtitles{Format: SUBTITLE_FORMATS.ASS, Cues: []SubtitleCue{}}
+	header := []string{}
+	events := false
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			events = strings.EqualFold(trimmed, "[Events]")
+			if events {continue}
+		}
+		if !events {
+			header = append(header, line)
+			continue
+		}
+
+		key, value, found := strings.Cut(trimmed, ":")
+		if !found {continue}
+		switch key {
+		case "Format":
+			subtitles.fields = []string{}
+			for _, field := range strings.Split(value, ",") {
+				subtitles.fields = append(subtitles.fields, strings.TrimSpace(field))
+			}
+		case "Dialogue":
+			if len(subtitles.fields) == 0 {subtitles.fields = defaultASSFields}
+			// the text is the last field and may contain commas
+			event := strings.SplitN(strings.TrimLeft(value, " "), ",", len(subtitles.fields))
+			if len(event) != len(subtitles.fields) {
+				return Subtitles{}, fmt.Errorf("cue %d: invalid dialogue %q", len(subtitles.Cues)+1, trimmed)
+			}
+			cue := SubtitleCue{event: event}
+			for i, field := range subtitles.fields {
+				var err error
+				switch field {
+				case "Start":
+					cue.Start, err = ParseTimecode(event[i], 0)
+				case "End":
+					cue.End, err = ParseTimecode(event[i], 0)
+				case "Text":
+					cue.Text = strings.ReplaceAll(event[i], `\N`, "\n")
+				}
+				if err != nil {
+					return Subtitles{}, fmt.Errorf("cue %d: %w", len(subtitles.Cues)+1, err)
+				}
+			}
+			subtitles.Cues = append(subtitles.Cues, cue)
+		}
+	}
+	if len(subtitles.fields) == 0 {
+		return Subtitles{}, errors.New("ASS file has no [Events] section")
+	}
+	subtitles.Header = strings.TrimSpace(strings.Join(header, "\n"))
+	return subtitles, nil
+}
+
+// Write writes the subtitles to subtitlesPath, converted to the format picked by its extension.
+func (subtitles Subtitles) Write(subtitlesPath string) error {
+	data, err := subtitles.Encode(subtitleFormat(subtitlesPath))
+	if err != nil {
+		Logger.Errorf("subtitlesPath: %s | %s", subtitlesPath, err)
+		return err
+	}
+	return os.WriteFile(subtitlesPath, []byte(data), 0644)
+}
+
+/*
+	Encode returns the subtitles as a file in format, one of SUBTITLE_FORMATS. Converting from ASS drops the override tags,
+	converting to ASS turns the <b>, <i> and <u> tags into their override tags and uses a default style.
+*/
+func (subtitles Subtitles) Encode(format string) (string, error) {
+	var builder strings.Builder
+	switch format {
+	case SUBTITLE_FORMATS.SRT:
+		for i, cue := range subtitles.Cues {
+			fmt.Fprintf(&builder, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(cue.Start), srtTime(cue.End), subtitles.plainText(cue))
+		}
+	case SUBTITLE_FORMATS.VTT:
+		header := "WEBVTT"
+		if subtitles.Format == SUBTITLE_FORMATS.VTT && subtitles.Header != "" {header = subtitles.Header}
+		builder.WriteString(header + "\n\n")
+		for _, cue := range subtitles.Cues {
+			timing := FormatTimecode(cue.Start) + " --> " + FormatTimecode(cue.End)
+			if cue.Settings != "" {timing += " " + cue.Settings}
+			fmt.Fprintf(&builder, "%s\n%s\n\n", timing, subtitles.plainText(cue))
+		}
+	case SUBTITLE_FORMATS.ASS:
+		header, fields := defaultASSHeader, defaultASSFields
+		if subtitles.Format == SUBTITLE_FORMATS.ASS && subtitles.Header != "" {
+			header, fields = subtitles.Header, subtitles.fields
+		}
+		fmt.Fprintf(&builder, "%s\n\n[Events]\nFormat: %s\n", header, strings.Join(fields, ", "))
+		for _, cue := range subtitles.Cues {
+			builder.WriteString("Dialogue: " + strings.Join(subtitles.event(cue, fields), ",") + "\n")
+		}
+	default:
+		return "", fmt.Errorf("unknown subtitle format %q", format)
+	}
+	return builder.String(), nil
+}
+
+var assOverrides = regexp.MustCompile(`\{[^}]*\}`)
+
+// plainText returns the text of cue for SRT and WebVTT.
+func (subtitles Subtitles) plainText(cue SubtitleCue) string {
+	if subtitles.Format != SUBTITLE_FORMATS.ASS {
+		return cue.Text
+	}
+	text := assOverrides.ReplaceAllString(cue.Text, "")
+	return strings.NewReplacer(`\n`, "\n", `\h`, " ").Replace(text)
+}
+
+// event returns the fields of the Dialogue line of cue.
+func (subtitles Subtitles) event(cue SubtitleCue, fields []string) []string {
+	text := cue.Text
+	if subtitles.Format != SUBTITLE_FORMATS.ASS {
+		text = strings.NewReplacer("<b>", `{\b1}`, "</b>", `{\b0}`, "<i>", `{\i1}`, "</i>", `{\i0}`, "<u>", `{\u1}`, "</u>", `{\u0}`).Replace(text)
+	}
+	event := cue.event
+	if len(event) != len(fields) {
+		event = make([]string, len(fields))
+	}
+	event = append([]string{}, event...)
+	for i, field := range fields {
+		switch field {
+		case "Start":
+			event[i] = assTime(cue.Start)
+		case "End":
+			event[i] = assTime(cue.End)
+		case "Text":
+			event[i] = strings.ReplaceAll(text, "\n", `\N`)
+		case "Layer", "MarginL", "MarginR", "MarginV":
+			if event[i] == "" {event[i] = "0"}
+		case "Style":
+			if event[i] == "" {event[i] = "Default"}
+		}
+	}
+	return event
+}
+
+// srtTime formats d as HH:MM:SS,mmm.
+func srtTime(d time.Duration) string {
+	return strings.Replace(FormatTimecode(d), ".", ",", 1)
+}
+
+// assTime formats d as H:MM:SS.cc.
+func assTime(d time.Duration) string {
+	centiseconds := d.Round(10 * time.Millisecond).Milliseconds() / 10
+	seconds := centiseconds / 100
+	return fmt.Sprintf("%d:%02d:%02d.%02d", seconds/3600, seconds%3600/60, seconds%60, centiseconds%100)
+}
+
+/*
+	Shift moves every cue by offset, e.g. -2 * time.Second shows them 2 seconds earlier. Cues that end before 0 are dropped
+	and cues that start before 0 are cut.
+*/
+func (subtitles Subtitles) Shift(offset time.Duration) Subtitles {
+	return subtitles.keep([]trimWindow{{start: -offset.Seconds(), end: math.Inf(1)}}, true)
+}
+
+// Trim keeps the cues from start to end, timed from start, as TrimRange does for a video.
+func (subtitles Subtitles) Trim(start time.Duration, end time.Duration) Subtitles {
+	return subtitles.keep([]trimWindow{{start: start.Seconds(), end: end.Seconds()}}, false)
+}
+
+/*
+	Keep returns the subtitles of sections played back to back, as ExtractSections followed by a concatenation or Skipper
+	produce them. Cues that overlap the end of a section are cut there.
+*/
+func (subtitles Subtitles) Keep(sections []TrimSection) Subtitles {
+	windows := []trimWindow{}
+	for _, section := range sections {
+		start, end := section.Range()
+		windows = append(windows, trimWindow{start: start.Seconds(), end: end.Seconds()})
+	}
+	return subtitles.keep(windows, false)
+}
+
+/*
+	keep cuts every cue to the windows, in seconds, and times the windows back to back from 0. Pieces of a cue cut by two
+	adjacent windows are joined. With negative starts allowed, the window only shifts the cues.
+*/
+func (subtitles Subtitles) keep(windows []trimWindow, shift bool) Subtitles {
+	kept := subtitles
+	kept.Cues = []SubtitleCue{}
+	position := 0.0
+	last := map[int]int{} // cue index to the index of its last piece
+	for _, window := range windows {
+		if !shift {window.start = math.Max(window.start, 0)}
+		if window.end <= window.start {continue}
+		for i, cue := range subtitles.Cues {
+			start, end := math.Max(cue.Start.Seconds(), window.start), math.Min(cue.End.Seconds(), window.end)
+			if end <= start {continue}
+			piece := cue
+			piece.Start = seconds(math.Max(start-window.start+position, 0))
+			piece.End = seconds(end - window.start + position)
+			if piece.End <= 0 {continue}
+			if previous, ok := last[i]; ok && kept.Cues[previous].End == piece.Start {
+				kept.Cues[previous].End = piece.End
+				continue
+			}
+			last[i] = len(kept.Cues)
+			kept.Cues = append(kept.Cues, piece)
+		}
+		position += window.end - window.start
+	}
+	sort.SliceStable(kept.Cues, func(i, j int) bool { return kept.Cues[i].Start < kept.Cues[j].Start })
+	return kept
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Round(value * float64(time.Second)))
+}
+
+/*
+	AdjustSubtitles returns subtitles timed to the rendered video: the trims applied to the video are applied to the cues,
+	so the file matches a render of the video. subtitles are timed to the source file.
+*/
+func (video Video) AdjustSubtitles(subtitles Subtitles) Subtitles {
+	window, ok := composeTrims(video.effects.args()["-ss"])
+	if !ok {
+		return subtitles
+	}
+	return subtitles.keep([]trimWindow{window}, false)
+}
@@ -0,0 +1,233 @@
+package animax_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pichan321/animax"
+)
+
+const srtFile = "\uFEFF1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\n<i>there</i>\r\n\r\n2\r\n00:00:04,000 --> 00:00:06,000\r\nBye\r\n"
+
+const vttFile = `WEBVTT - episode 1
+
+STYLE
+::cue { color: yellow }
+
+NOTE written by hand
+
+intro
+00:01.000 --> 00:02.500 align:start line:90%
+Hello
+
+00:00:04.000 --> 00:00:06.000
+Bye
+`
+
+const assFile = `[Script Info]
+Title: Episode 1
+
+[V4+ Styles]
+Style: Sign,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,0,8,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Text
+Dialogue: 0,0:00:01.00,0:00:02.50,Sign,{\an8}Hello, there\Nfriend
+Dialogue: 0,0:00:04.00,0:00:06.00,Default,Bye
+`
+
+// cue is a cue timed in milliseconds, to keep the tables short.
+type cue struct {
+	start, end int
+	text       string
+}
+
+func cues(subtitles animax.Subtitles) []cue {
+	result := []cue{}
+	for _, c := range subtitles.Cues {
+		result = append(result, cue{int(c.Start / time.Millisecond), int(c.End / time.Millisecond), c.Text})
+	}
+	return result
+}
+
+func TestParseSubtitles(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   []cue
+	}{
+		{"srt", srtFile, animax.SUBTITLE_FORMATS.SRT, []cue{{1000, 2500, "Hello\n<i>there</i>"}, {4000, 6000, "Bye"}}},
+		{"srt without counters", "00:00:01,000 --> 00:00:02,000\nHello\n\n\n\n00:00:03,000 --> 00:00:04,000\nBye", animax.SUBTITLE_FORMATS.SRT, []cue{{1000, 2000, "Hello"}, {3000, 4000, "Bye"}}},
+		{"empty srt", "\n\n", animax.SUBTITLE_FORMATS.SRT, []cue{}},
+		{"vtt", vttFile, animax.SUBTITLE_FORMATS.VTT, []cue{{1000, 2500, "Hello"}, {4000, 6000, "Bye"}}},
+		{"ass", assFile, animax.SUBTITLE_FORMATS.ASS, []cue{{1000, 2500, "{\\an8}Hello, there\nfriend"}, {4000, 6000, "Bye"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subtitles, err := animax.ParseSubtitles(test.data, test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cues(subtitles); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got cues %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	vtt, _ := animax.ParseSubtitles(vttFile, animax.SUBTITLE_FORMATS.VTT)
+	if vtt.Cues[0].Settings != "align:start line:90%" {
+		t.Errorf("got settings %q", vtt.Cues[0].Settings)
+	}
+	if want := "WEBVTT - episode 1\n\nSTYLE\n::cue { color: yellow }"; vtt.Header != want {
+		t.Errorf("got header %q, want %q", vtt.Header, want)
+	}
+}
+
+func TestParseMalformedSubtitles(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		format  string
+		message string
+	}{
+		{"srt timing without an arrow", "1\n00:00:01,000 - 00:00:02,000\nHello", animax.SUBTITLE_FORMATS.SRT, "cue 1"},
+		{"srt cue without a timing", "1\n00:00:01,000 --> 00:00:02,000\nHello\n\n2\nBye", animax.SUBTITLE_FORMATS.SRT, "cue 2"},
+		{"srt timing without an end", "1\n00:00:01,000 -->\nHello", animax.SUBTITLE_FORMATS.SRT, "invalid timing"},
+		{"srt start out of range", "1\n00:61:00,000 --> 00:62:00,000\nHello", animax.SUBTITLE_FORMATS.SRT, "cue 1"},
+		{"srt non-finite start", "1\nNaN --> 00:00:02,000\nHello", animax.SUBTITLE_FORMATS.SRT, "cue 1"},
+		{"srt exponent end", "1\n00:00:01,000 --> 1e400\nHello", animax.SUBTITLE_FORMATS.SRT, "cue 1"},
+		{"vtt without header", "00:01.000 --> 00:02.000\nHello", animax.SUBTITLE_FORMATS.VTT, "WEBVTT"},
+		{"empty vtt", "", animax.SUBTITLE_FORMATS.VTT, "WEBVTT"},
+		{"vtt negative end", "WEBVTT\n\n00:01.000 --> -00:02.000\nHello", animax.SUBTITLE_FORMATS.VTT, "cue 1"},
+		{"vtt identifier without a timing", "WEBVTT\n\nintro\nHello", animax.SUBTITLE_FORMATS.VTT, "cue 1"},
+		{"ass without events", "[Script Info]\nTitle: Episode 1\n", animax.SUBTITLE_FORMATS.ASS, "[Events]"},
+		{"ass dialogue missing fields", "[Events]\nFormat: Layer, Start, End, Style, Text\nDialogue: 0,0:00:01.00,0:00:02.00", animax.SUBTITLE_FORMATS.ASS, "cue 1: invalid dialogue"},
+		{"ass invalid start", "[Events]\nFormat: Layer, Start, End, Style, Text\nDialogue: 0,0:00:01.00,0:00:02.00,Default,Hello\nDialogue: 0,0:00:xx.00,0:00:04.00,Default,Bye", animax.SUBTITLE_FORMATS.ASS, "cue 2"},
+		{"ass infinite end", "[Events]\nFormat: Layer, Start, End, Style, Text\nDialogue: 0,0:00:01.00,Inf,Default,Hello", animax.SUBTITLE_FORMATS.ASS, "cue 1"},
+		{"unknown format", "Hello", ".sub", "unknown subtitle format"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := animax.ParseSubtitles(test.data, test.format)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("got %v, want an error mentioning %q", err, test.message)
+			}
+		})
+	}
+}
+
+func TestEncodeSubtitles(t *testing.T) {
+	parse := func(data string, format string) animax.Subtitles {
+		t.Helper()
+		subtitles, err := animax.ParseSubtitles(data, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return subtitles
+	}
+	srt, vtt, ass := parse(srtFile, animax.SUBTITLE_FORMATS.SRT), parse(vttFile, animax.SUBTITLE_FORMATS.VTT), parse(assFile, animax.SUBTITLE_FORMATS.ASS)
+
+	tests := []struct {
+		name      string
+		subtitles animax.Subtitles
+		format    string
+		want      string
+	}{
+		{"srt to srt", srt, animax.SUBTITLE_FORMATS.SRT, "1\n00:00:01,000 --> 00:00:02,500\nHello\n<i>there</i>\n\n2\n00:00:04,000 --> 00:00:06,000\nBye\n\n"},
+		{"srt to vtt", srt, animax.SUBTITLE_FORMATS.VTT, "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\n<i>there</i>\n\n00:00:04.000 --> 00:00:06.000\nBye\n\n"},
+		{"vtt keeps its header and settings", vtt, animax.SUBTITLE_FORMATS.VTT, "WEBVTT - episode 1\n\nSTYLE\n::cue { color: yellow }\n\n00:00:01.000 --> 00:00:02.500 align:start line:90%\nHello\n\n00:00:04.000 --> 00:00:06.000\nBye\n\n"},
+		{"ass to srt drops the override tags", ass, animax.SUBTITLE_FORMATS.SRT, "1\n00:00:01,000 --> 00:00:02,500\nHello, there\nfriend\n\n2\n00:00:04,000 --> 00:00:06,000\nBye\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.subtitles.Encode(test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("ass keeps its header, fields and styles", func(t *testing.T) {
+		got, err := ass.Encode(animax.SUBTITLE_FORMATS.ASS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := assFile; got != want {
+			t.Errorf("got\n%q\nwant\n%q", got, want)
+		}
+	})
+
+	t.Run("srt to ass", func(t *testing.T) {
+		got, err := srt.Encode(animax.SUBTITLE_FORMATS.ASS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+			"Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,Hello\\N{\\i1}there{\\i0}\n" +
+			"Dialogue: 0,0:00:04.00,0:00:06.00,Default,,0,0,0,,Bye\n"
+		if !strings.HasPrefix(got, "[Script Info]") || !strings.HasSuffix(got, want) {
+			t.Errorf("got\n%s\nwant the default header followed by\n%s", got, want)
+		}
+		// and back, the cues survive the round trip
+		if back := parse(got, animax.SUBTITLE_FORMATS.ASS); !reflect.DeepEqual(cues(back)[1], cues(srt)[1]) {
+			t.Errorf("got %v after the round trip, want %v", cues(back), cues(srt))
+		}
+	})
+
+	if _, err := srt.Encode(".sub"); err == nil {
+		t.Error("encoded an unknown format")
+	}
+}
+
+func TestShiftSubtitles(t *testing.T) {
+	subtitles, err := animax.ParseSubtitles(srtFile, animax.SUBTITLE_FORMATS.SRT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		offset time.Duration
+		want   []cue
+	}{
+		{0, []cue{{1000, 2500, "Hello\n<i>there</i>"}, {4000, 6000, "Bye"}}},
+		{1500 * time.Millisecond, []cue{{2500, 4000, "Hello\n<i>there</i>"}, {5500, 7500, "Bye"}}},
+		{-2 * time.Second, []cue{{0, 500, "Hello\n<i>there</i>"}, {2000, 4000, "Bye"}}},
+		{-5 * time.Second, []cue{{0, 1000, "Bye"}}},
+		{-10 * time.Second, []cue{}},
+	}
+	for _, test := range tests {
+		if got := cues(subtitles.Shift(test.offset)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Shift(%s) = %v, want %v", test.offset, got, test.want)
+		}
+	}
+	if got := cues(subtitles); got[0].start != 1000 {
+		t.Errorf("Shift changed the original cues: %v", got)
+	}
+}
+
+func TestTrimSubtitles(t *testing.T) {
+	subtitles, err := animax.ParseSubtitles(srtFile, animax.SUBTITLE_FORMATS.SRT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		start, end time.Duration
+		want       []cue
+	}{
+		{0, 10 * time.Second, []cue{{1000, 2500, "Hello\n<i>there</i>"}, {4000, 6000, "Bye"}}},
+		{2 * time.Second, 5 * time.Second, []cue{{0, 500, "Hello\n<i>there</i>"}, {2000, 3000, "Bye"}}},
+		{3 * time.Second, 4 * time.Second, []cue{}},
+		{5 * time.Second, 3 * time.Second, []cue{}},
+		{-time.Second, 2 * time.Second, []cue{{1000, 2000, "Hello\n<i>there</i>"}}},
+	}
+	for _, test := range tests {
+		if got := cues(subtitles.Trim(test.start, test.end)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Trim(%s, %s) = %v, want %v", test.start, test.end, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package animax
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var TEXT_POSITIONS = struct {
+	TopLeft      string
+	TopCenter    string
+	TopRight     string
+	CenterLeft   string
+	Center       string
+	CenterRight  string
+	BottomLeft   string
+	BottomCenter string
+	BottomRight  string
+}{
+	TopLeft:      "top-left",
+	TopCenter:    "top-center",
+	TopRight:     "top-right",
+	CenterLeft:   "center-left",
+	Center:       "center",
+	CenterRight:  "center-right",
+	BottomLeft:   "bottom-left",
+	BottomCenter: "bottom-center",
+	BottomRight:  "bottom-right",
+}
+
+/*
+	TextOverlay is a text drawn over a video by DrawText. Colors use the ffmpeg syntax, e.g. "white", "#ffcc00" or "black@0.5".
+	Start and End are relative to the rendered output, an End of 0 keeps the text until the end. FadeOut needs an End.
+*/
+type TextOverlay struct {
+	Text     string
+	FontFile string // .ttf or .otf file, the default font of ffmpeg when empty
+	FontSize int    // 48 by default
+	Color    string // white by default
+
+	BorderWidth int
+	BorderColor string // black by default
+	ShadowX     int
+	ShadowY     int
+	ShadowColor string // black@0.6 by default
+
+	Box        bool
+	BoxColor   string // black@0.5 by default
+	BoxPadding int
+
+	Position string // one of TEXT_POSITIONS, bottom-center by default
+	Margin   int    // distance to the edges of the frame in pixels, 40 by default
+	X        string // ffmpeg expressions replacing Position, e.g. "(w-text_w)/2"
+	Y        string
+
+	Start   time.Duration
+	End     time.Duration
+	FadeIn  time.Duration
+	FadeOut time.Duration
+}
+
+/*
+	DrawText burns text onto the video. It is a video filter like the others and runs in chain order.
+
+		clip := video.DrawText(animax.TextOverlay{
+			Text: "Follow for part 2", FontFile: "Inter-Bold.ttf", FontSize: 64, Box: true,
+			Position: animax.TEXT_POSITIONS.BottomCenter, Start: 2 * time.Second, FadeIn: 500 * time.Millisecond,
+		})
+*/
+func (video *Video) DrawText(overlay TextOverlay) (modifiedVideo *Video) {
+	if overlay.Text == "" {
+		Logger.Warn("DrawText requires a text")
+		return &Video{}
+	}
+	if _, _, ok := overlay.position(); !ok {
+		Logger.Warnf("Unknown text position %q", overlay.Position)
+		return &Video{}
+	}
+	return video.withEffect("-filter_complex",
+		subArg{
+			Key: "drawtext",
+			Value: overlay.filter(),
+		})
+}
+
+// position returns the x and y expressions of the overlay.
+func (overlay TextOverlay) position() (string, string, bool) {
+	margin := overlay.Margin
+	if margin == 0 {margin = 40}
+	left, center, right := fmt.Sprint(margin), "(w-text_w)/2", fmt.Sprintf("w-text_w-%d", margin)
+	top, middle, bottom := fmt.Sprint(margin), "(h-text_h)/2", fmt.Sprintf("h-text_h-%d", margin)
+
+	x, y := "", ""
+	switch overlay.Position {
+	case TEXT_POSITIONS.TopLeft:
+		x, y = left, top
+	case TEXT_POSITIONS.TopCenter:
+		x, y = center, top
+	case TEXT_POSITIONS.TopRight:
+		x, y = right, top
+	case TEXT_POSITIONS.CenterLeft:
+		x, y = left, middle
+	case TEXT_POSITIONS.Center:
+		x, y = center, middle
+	case TEXT_POSITIONS.CenterRight:
+		x, y = right, middle
+	case TEXT_POSITIONS.BottomLeft:
+		x, y = left, bottom
+	case TEXT_POSITIONS.BottomCenter, "":
+		x, y = center, bottom
+	case TEXT_POSITIONS.BottomRight:
+		x, y = right, bottom
+	default:
+		return "", "", false
+	}
+	if overlay.X != "" {x = overlay.X}
+	if overlay.Y != "" {y = overlay.Y}
+	return x, y, true
+}
+
+// filter returns the drawtext filter. The input is seeked, so the filter graph sees the timestamps of the rendered output.
+func (overlay TextOverlay) filter() string {
+	fontSize := overlay.FontSize
+	if fontSize <= 0 {fontSize = 48}
+	x, y, _ := overlay.position()
+
+	options := [][2]string{{"text", overlay.Text}, {"expansion", "none"}}
+	if overlay.FontFile != "" {
+		options = append(options, [2]string{"fontfile", overlay.FontFile})
+	}
+	options = append(options,
+		[2]string{"fontsize", fmt.Sprint(fontSize)},
+		[2]string{"fontcolor", orDefault(overlay.Color, "white")},
+		[2]string{"x", x},
+		[2]string{"y", y},
+	)
+	if overlay.BorderWidth > 0 {
+		options = append(options, [2]string{"borderw", fmt.Sprint(overlay.BorderWidth)}, [2]string{"bordercolor", orDefault(overlay.BorderColor, "black")})
+	}
+	if overlay.ShadowX != 0 || overlay.ShadowY != 0 {
+		options = append(options,
+			[2]string{"shadowx", fmt.Sprint(overlay.ShadowX)},
+			[2]string{"shadowy", fmt.Sprint(overlay.ShadowY)},
+			[2]string{"shadowcolor", orDefault(overlay.ShadowColor, "black@0.6")},
+		)
+	}
+	if overlay.Box {
+		options = append(options, [2]string{"box", "1"}, [2]string{"boxcolor", orDefault(overlay.BoxColor, "black@0.5")})
+		if overlay.BoxPadding > 0 {
+			options = append(options, [2]string{"boxborderw", fmt.Sprint(overlay.BoxPadding)})
+		}
+	}
+
+	start := overlay.Start.Seconds()
+	end := overlay.End.Seconds()
+	if overlay.End > 0 {
+		options = append(options, [2]string{"enable", fmt.Sprintf("between(t,%f,%f)", start, end)})
+	} else if start > 0 {
+		options = append(options, [2]string{"enable", fmt.Sprintf("gte(t,%f)", start)})
+	}
+	if alpha := overlay.alpha(start, end); alpha != "" {
+		options = append(options, [2]string{"alpha", alpha})
+	}
+
+	return "drawtext=" + joinFilterOptions(options)
+}
+
+// alpha returns the opacity expression fading the text in after start and out before end, or "" without fades.
+func (overlay TextOverlay) alpha(start float64, end float64) string {
+	fadeIn, fadeOut := overlay.FadeIn.Seconds(), overlay.FadeOut.Seconds()
+	if overlay.End <= 0 {fadeOut = 0}
+
+	alpha := "1"
+	if fadeOut > 0 {
+		alpha = fmt.Sprintf("if(gt(t,%f),(%f-t)/%f,%s)", end-fadeOut, end, fadeOut, alpha)
+	}
+	if fadeIn > 0 {
+		alpha = fmt.Sprintf("if(lt(t,%f),(t-%f)/%f,%s)", start+fadeIn, start, fadeIn, alpha)
+	}
+	if alpha == "1" {
+		return ""
+	}
+	return alpha
+}
+
+/*
+	escapeFilterValue escapes value for an option of a filter inside a -filter_complex graph: first for the option parser,
+	then for the graph parser, as described in the "Notes on filtergraph escaping" of the ffmpeg documentation.
+*/
+func escapeFilterValue(value string) string {
+	option := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(option)
+}
+
+// joinFilterOptions returns the key=value options of a filter, escaped.
+func joinFilterOptions(options [][2]string) string {
+	values := []string{}
+	for _, option := range options {
+		values = append(values, option[0]+"="+escapeFilterValue(option[1]))
+	}
+	return strings.Join(values, ":")
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
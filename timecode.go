@@ -0,0 +1,94 @@
+package animax
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTimecodeSeconds is the first number of seconds a time.Duration cannot hold (MaxInt64 rounds up to 2^63 as a float)
+const maxTimecodeSeconds = float64(math.MaxInt64) / float64(time.Second)
+
+/*
+	ParseTimecode parses the timecodes accepted by the time-based APIs:
+		"HH:MM:SS.mmm", "MM:SS.mmm" or "SS.mmm"  (fractions are optional)
+		"f123"                                    (frame number, needs the frame rate of the video)
+*/
+func ParseTimecode(value string, fps float64) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty timecode")
+	}
+
+	if strings.HasPrefix(value, "f") {
+		frame, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil || frame < 0 {
+			return 0, fmt.Errorf("invalid frame timecode %q", value)
+		}
+		if fps <= 0 {
+			return 0, fmt.Errorf("frame timecode %q needs a frame rate", value)
+		}
+		seconds := float64(frame) / fps
+		if seconds >= maxTimecodeSeconds {
+			return 0, fmt.Errorf("timecode %q is too long", value)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timecode %q", value)
+	}
+
+	// ParseFloat also reads NaN, Inf, exponents and hexadecimal floats, none of which are timecodes
+	if !isDecimal(parts[len(parts)-1]) {
+		return 0, fmt.Errorf("invalid seconds in timecode %q", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds < 0 || (len(parts) > 1 && seconds >= 60) {
+		return 0, fmt.Errorf("invalid seconds in timecode %q", value)
+	}
+
+	total := seconds
+	multiplier := 60.0
+	for i := len(parts) - 2; i >= 0; i-- {
+		// Atoi also reads a sign, which would make "-00:30" a positive timecode
+		unit, err := strconv.Atoi(parts[i])
+		if err != nil || strings.Trim(parts[i], "0123456789") != "" || (i > 0 && unit >= 60) {
+			return 0, fmt.Errorf("invalid timecode %q", value)
+		}
+		total += float64(unit) * multiplier
+		multiplier *= 60
+	}
+	if total >= maxTimecodeSeconds {
+		return 0, fmt.Errorf("timecode %q is too long", value)
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// isDecimal reports whether value is made of digits with an optional fraction, e.g. "12" or "12.480".
+func isDecimal(value string) bool {
+	digits, dots := 0, 0
+	for _, char := range value {
+		switch {
+		case char >= '0' && char <= '9':
+			digits++
+		case char == '.':
+			dots++
+		default:
+			return false
+		}
+	}
+	return digits > 0 && dots <= 1
+}
+
+// FormatTimecode formats d as HH:MM:SS.mmm, the format ffmpeg accepts for every time option.
+func FormatTimecode(d time.Duration) string {
+	if d < 0 {
+		return "-" + FormatTimecode(-d)
+	}
+	milliseconds := d.Round(time.Millisecond).Milliseconds()
+	return fmt.Sprintf("%s.%03d", secondsToHMS(int(milliseconds/1000)), milliseconds%1000)
+}
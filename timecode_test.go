@@ -0,0 +1,103 @@
+package animax_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pichan321/animax"
+)
+
+func TestParseTimecode(t *testing.T) {
+	tests := []struct {
+		value string
+		fps   float64
+		want  time.Duration
+	}{
+		{"12", 0, 12 * time.Second},
+		{" 12.5 ", 0, 12500 * time.Millisecond},
+		{".25", 0, 250 * time.Millisecond},
+		{"90", 0, 90 * time.Second},
+		{"01:30", 0, 90 * time.Second},
+		{"1:02:03.040", 0, time.Hour + 2*time.Minute + 3040*time.Millisecond},
+		{"100:00:00", 0, 100 * time.Hour},
+		{"f0", 30, 0},
+		{"f45", 30, 1500 * time.Millisecond},
+		{"f1001", 30000.0 / 1001, 33400 * time.Millisecond},
+	}
+	for _, test := range tests {
+		got, err := animax.ParseTimecode(test.value, test.fps)
+		if err != nil {
+			t.Errorf("ParseTimecode(%q): %s", test.value, err)
+			continue
+		}
+		if diff := got - test.want; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Errorf("ParseTimecode(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseTimecodeRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		fps   float64
+	}{
+		{"empty", "  ", 30},
+		{"NaN", "NaN", 30},
+		{"infinity", "Inf", 30},
+		{"signed infinity", "+Inf", 30},
+		{"infinite minutes", "01:inf", 30},
+		{"exponent", "1e3", 30},
+		{"overflowing exponent", "1e400", 30},
+		{"hexadecimal float", "0x1p4", 30},
+		{"negative", "-5", 30},
+		{"explicit sign", "+5", 30},
+		{"negative hours", "-00:00:30", 30},
+		{"negative minutes", "-00:30", 30},
+		{"signed minutes", "+01:30", 30},
+		{"two dots", "1.2.3", 30},
+		{"only a dot", ".", 30},
+		{"seconds past a minute", "01:60", 30},
+		{"minutes past an hour", "1:60:00", 30},
+		{"fractional minutes", "1.5:00", 30},
+		{"too many fields", "1:00:00:00", 30},
+		{"empty field", "1::00", 30},
+		{"seconds overflow", "99999999999999999999", 30},
+		{"hours overflow", "9999999999:00:00", 30},
+		{"hours beyond int", "99999999999999999999:00:00", 30},
+		{"frame without rate", "f30", 0},
+		{"negative frame", "f-30", 30},
+		{"fractional frame", "f1.5", 30},
+		{"frame overflow", "f99999999999999999999", 30},
+		{"frame past the longest duration", "f9000000000000000000", 1},
+		{"bare f", "f", 30},
+	}
+	for _, test := range tests {
+		if got, err := animax.ParseTimecode(test.value, test.fps); err == nil {
+			t.Errorf("%s: ParseTimecode(%q) = %s, want an error", test.name, test.value, got)
+		}
+	}
+}
+
+func TestFormatTimecode(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "00:00:00.000",
+		1500 * time.Millisecond: "00:00:01.500",
+		time.Hour + 2*time.Minute + 3040*time.Millisecond: "01:02:03.040",
+		-90 * time.Second: "-00:01:30.000",
+	}
+	for duration, want := range tests {
+		if got := animax.FormatTimecode(duration); got != want {
+			t.Errorf("FormatTimecode(%s) = %q, want %q", duration, got, want)
+		}
+	}
+	// formatted timecodes parse back to the same duration
+	for duration := range tests {
+		if duration < 0 {
+			continue
+		}
+		if got, err := animax.ParseTimecode(animax.FormatTimecode(duration), 0); err != nil || got != duration {
+			t.Errorf("ParseTimecode(FormatTimecode(%s)) = %s, %v", duration, got, err)
+		}
+	}
+}
@@ -21,12 +21,27 @@ type PageUpload struct {
 	Description string
 	Token string
 	PageId string
+
+	// when Store is set, UploadToFacebookVideoPage records the acknowledged offsets under JobID and resumes from them
+	Store animax.JobStore
+	JobID string
+
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
 }
 
 const mb = 1024 * 1024 // 1 MB
 const max_reel_size = 250 * 1024 * 1024 //max reel size allowed is 250 MB
 const max_video_size = 10000 * 1024 * 1024 //max video size allowed is 10 GB
 
+const reelsEndpoint = `https://graph.facebook.com/v13.0/%s/video_reels`
+const videosEndpoint = `https://graph-video.facebook.com/v17.0/%s/videos`
+
+func (upload PageUpload) client() *http.Client {
+	if upload.Client != nil {return upload.Client}
+	return http.DefaultClient
+}
+
 func previewBytes(file *os.File) bool {
 	buffer := make([]byte, 5 * mb)
 	n, err := file.Read(buffer)
@@ -40,22 +55,49 @@ func previewBytes(file *os.File) bool {
 	return true
 }
 
-func UploadToFacebookReelPage(upload PageUpload) error {
+// checkFile returns the info of the file to upload once the token and the file are usable.
+func (upload PageUpload) checkFile(maxSize int64) (os.FileInfo, error) {
 	if !(len(upload.Token) > 0) {
-		return errors.New("token length must be at least 1 character")
+		return nil, errors.New("token length must be at least 1 character")
 	}
 
 	fileInfo, err := os.Stat(upload.FilePath)
 	if err != nil {
-		return errors.New("invalid video path")
+		return nil, errors.New("invalid video path")
 	}
 
 	if fileInfo.IsDir() {
-		return errors.New("path must be a file, not a directory")
+		return nil, errors.New("path must be a file, not a directory")
+	}
+
+	if fileInfo.Size() > maxSize {
+		return nil, errors.New(fmt.Sprintf(`max allowed file size is %d`, maxSize))
+	}
+	return fileInfo, nil
+}
+
+// ReelSteps describes the requests UploadToFacebookReelPage makes, without making them. The token is left out.
+func (upload PageUpload) ReelSteps() ([]string, error) {
+	fileInfo, err := upload.checkFile(max_reel_size)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf(reelsEndpoint, upload.PageId)
+	return []string{
+		fmt.Sprintf("POST %s upload_phase=start", endpoint),
+		fmt.Sprintf("POST <upload_url of the start response> %d bytes of %s", fileInfo.Size(), upload.FilePath),
+		fmt.Sprintf("POST %s upload_phase=finish video_state=PUBLISHED description=%q", endpoint, upload.Description),
+	}, nil
+}
+
+func UploadToFacebookReelPage(upload PageUpload) error {
+	fileInfo, err := upload.checkFile(max_reel_size)
+	if err != nil {
+		return err
 	}
 
-	client := &http.Client{}
-	requestUrl, err := url.Parse(fmt.Sprintf(`https://graph.facebook.com/v13.0/%s/video_reels`, upload.PageId))
+	client := upload.client()
+	requestUrl, err := url.Parse(fmt.Sprintf(reelsEndpoint, upload.PageId))
 	if err != nil {
 		return err
 	}
@@ -88,9 +130,6 @@ func UploadToFacebookReelPage(upload PageUpload) error {
 	if err != nil {
 		return errors.New("unable to open file")
 	}
-	if fileInfo.Size() > max_reel_size {
-		return errors.New(fmt.Sprintf(`max allowed file size is %d`, max_reel_size))
-	}
 	ok := previewBytes(file)
 	if !ok {
 		return errors.New("unable to read file content")
@@ -119,7 +158,7 @@ func UploadToFacebookReelPage(upload PageUpload) error {
 	if err != nil {return err}
 	if bodyMap == nil {return errors.New("invalid body response from Facebook")}
 
-	requestUrl, err = url.Parse(fmt.Sprintf(`https://graph.facebook.com/v13.0/%s/video_reels`, upload.PageId))
+	requestUrl, err = url.Parse(fmt.Sprintf(reelsEndpoint, upload.PageId))
 	if err != nil {return err}
 
 	params = requestUrl.Query()
@@ -151,63 +190,53 @@ func UploadToFacebookReelPage(upload PageUpload) error {
 	return nil
 }
 
-func UploadToFacebookVideoPage(upload PageUpload) error {
-	if !(len(upload.Token) > 0) {
-		return errors.New("token length must be at least 1 character")
-	}
-
-	fileInfo, err := os.Stat(upload.FilePath)
+/*
+	VideoSteps describes the requests UploadToFacebookVideoPage makes, without making them. The token is left out.
+	A session recorded in Store is resumed from its offset.
+*/
+func (upload PageUpload) VideoSteps() ([]string, error) {
+	fileInfo, err := upload.checkFile(max_video_size)
 	if err != nil {
-		return errors.New("invalid video path")
+		return nil, err
 	}
-
-	if fileInfo.IsDir() {
-		return errors.New("path must be a file, not a directory")
+	endpoint := fmt.Sprintf(videosEndpoint, upload.PageId)
+	steps := []string{}
+	var startOffset int64
+	if session, resumed := upload.session(fileInfo.Size()); resumed {
+		steps = append(steps, fmt.Sprintf("resume upload session %s", session.SessionID))
+		startOffset = session.StartOffset
+	} else {
+		steps = append(steps, fmt.Sprintf("POST %s upload_phase=start file_size=%d", endpoint, fileInfo.Size()))
 	}
+	return append(steps,
+		fmt.Sprintf("POST %s upload_phase=transfer start_offset=%d, repeated until the %d bytes of %s are sent", endpoint, startOffset, fileInfo.Size(), upload.FilePath),
+		fmt.Sprintf("POST %s upload_phase=finish title=%q description=%q", endpoint, upload.Title, upload.Description),
+	), nil
+}
 
-	if fileInfo.Size() > max_video_size {
-		return errors.New(fmt.Sprintf(`max allowed file size is %d`, max_video_size))
-	}
-	
-	client := &http.Client{}
-	requestUrl, err := url.Parse(fmt.Sprintf(`https://graph-video.facebook.com/v17.0/%s/videos`, upload.PageId))
-	if err != nil {
-		return err
-	}
-	params := requestUrl.Query()
-	params.Add("access_token", upload.Token)
-	params.Add("upload_phase", "start")
-	params.Add("file_size", fmt.Sprintf(`%d`, fileInfo.Size()))
-	requestUrl.RawQuery = params.Encode()
-	req, err := http.NewRequest("POST", requestUrl.String(), nil)
-	if err != nil {return err}
-	response, err := client.Do(req)
-	if err != nil {return err}
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return err
-	}
-	bodyMap := make(map[string]interface{})
-	err = json.Unmarshal(body, &bodyMap)
+func UploadToFacebookVideoPage(upload PageUpload) error {
+	fileInfo, err := upload.checkFile(max_video_size)
 	if err != nil {
 		return err
 	}
-	if bodyMap == nil {return errors.New("invalid body response from Facebook")}
-	if _, ok := bodyMap["upload_session_id"]; !ok {
-		return errors.New("invalid body response from Facebook")
-	}
-	if _, ok := bodyMap["end_offset"]; !ok {
-		return errors.New("invalid body response from Facebook")
+	
+	client := upload.client()
+	session, resumed := upload.session(fileInfo.Size())
+	if !resumed {
+		session, err = startVideoSession(client, upload, fileInfo.Size())
+		if err != nil {return err}
+		upload.saveSession(&session)
+	} else {
+		animax.Logger.Infof("Resuming upload session %s at offset %d", session.SessionID, session.StartOffset)
 	}
 
-
-	sessionId := bodyMap["upload_session_id"].(string)
-	var startOffset int64 = 0
-	endOffsetStr := bodyMap["end_offset"].(string)
-	endOffset, err := strconv.ParseInt(endOffsetStr, 10, 64)
-	if err != nil {
-		return err
-	}
+	sessionId := session.SessionID
+	startOffset := session.StartOffset
+	endOffset := session.EndOffset
+	var req *http.Request
+	var response *http.Response
+	var body []byte
+	var bodyMap map[string]interface{}
 
 	file, err :=os.Open(upload.FilePath)
 	if err != nil {
@@ -217,6 +246,9 @@ func UploadToFacebookVideoPage(upload PageUpload) error {
 	if !ok {
 		return errors.New("unable to read file content")
 	}
+	if _, err := file.Seek(startOffset, io.SeekStart); err != nil {
+		return err
+	}
 	// background := context.Background()
 	// deadline, cancel := context.WithDeadline(background, time.Now().Add(time.Hour * 1))
 	for {
@@ -271,7 +303,7 @@ func UploadToFacebookVideoPage(upload PageUpload) error {
 			return err
 		}
 
-		uploadUrl, err := url.Parse(fmt.Sprintf(`https://graph-video.facebook.com/v17.0/%s/videos`, upload.PageId))
+		uploadUrl, err := url.Parse(fmt.Sprintf(videosEndpoint, upload.PageId))
 		if err != nil {return err}
 
 		req, err = http.NewRequest("POST", uploadUrl.String(), bodyMulti)
@@ -304,6 +336,8 @@ func UploadToFacebookVideoPage(upload PageUpload) error {
 		if err != nil {return err}
 
 		animax.Logger.Warnf("Start: %d, End: %d", startOffset, endOffset)
+		session.StartOffset, session.EndOffset = startOffset, endOffset
+		upload.saveSession(&session)
 		// select {
 		// case <-deadline.Done():
 		// 	cancel()
@@ -319,13 +353,13 @@ func UploadToFacebookVideoPage(upload PageUpload) error {
 	}
 	
 	animax.Logger.Warn("Loop exited")
-	publishUrl, err := url.Parse(fmt.Sprintf(`https://graph-video.facebook.com/v17.0/%s/videos`, upload.PageId))
+	publishUrl, err := url.Parse(fmt.Sprintf(videosEndpoint, upload.PageId))
 	if err != nil {
 		// cancel()
 		return err
 	}
 
-	params = publishUrl.Query()
+	params := publishUrl.Query()
 	params.Add("upload_phase", "finish")
 	params.Add("access_token", upload.Token)
 	params.Add("upload_session_id", sessionId)
@@ -352,9 +386,83 @@ func UploadToFacebookVideoPage(upload PageUpload) error {
 	}
 
 	animax.Logger.Infof("Upload published: %s", body)
+	upload.saveSession(nil)
 
 	defer file.Close()
 	// defer cancel()
 	defer response.Body.Close()
 	return nil
+}
+
+func startVideoSession(client *http.Client, upload PageUpload, fileSize int64) (animax.UploadSession, error) {
+	session := animax.UploadSession{FilePath: upload.FilePath, FileSize: fileSize}
+	requestUrl, err := url.Parse(fmt.Sprintf(videosEndpoint, upload.PageId))
+	if err != nil {
+		return session, err
+	}
+	params := requestUrl.Query()
+	params.Add("access_token", upload.Token)
+	params.Add("upload_phase", "start")
+	params.Add("file_size", fmt.Sprintf(`%d`, fileSize))
+	requestUrl.RawQuery = params.Encode()
+	req, err := http.NewRequest("POST", requestUrl.String(), nil)
+	if err != nil {return session, err}
+	response, err := client.Do(req)
+	if err != nil {return session, err}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return session, err
+	}
+	bodyMap := make(map[string]interface{})
+	err = json.Unmarshal(body, &bodyMap)
+	if err != nil {
+		return session, err
+	}
+	if bodyMap == nil {return session, errors.New("invalid body response from Facebook")}
+	if _, ok := bodyMap["upload_session_id"]; !ok {
+		return session, errors.New("invalid body response from Facebook")
+	}
+	if _, ok := bodyMap["end_offset"]; !ok {
+		return session, errors.New("invalid body response from Facebook")
+	}
+
+	session.SessionID = bodyMap["upload_session_id"].(string)
+	session.EndOffset, err = strconv.ParseInt(bodyMap["end_offset"].(string), 10, 64)
+	return session, err
+}
+
+// session returns the upload session recorded for the job, when it is for the same file.
+func (upload PageUpload) session(fileSize int64) (animax.UploadSession, bool) {
+	if upload.Store == nil {
+		return animax.UploadSession{}, false
+	}
+	record, err := upload.Store.Load(upload.JobID)
+	if err != nil || record.Upload == nil {
+		return animax.UploadSession{}, false
+	}
+	session := *record.Upload
+	if session.SessionID == "" || session.FilePath != upload.FilePath || session.FileSize != fileSize {
+		return animax.UploadSession{}, false
+	}
+	return session, true
+}
+
+// saveSession records session for the job, or clears it once the upload is published.
+func (upload PageUpload) saveSession(session *animax.UploadSession) {
+	if upload.Store == nil {
+		return
+	}
+	err := upload.Store.Update(upload.JobID, func(record *animax.JobRecord) {
+		record.Upload = session
+		switch {
+		case session == nil:
+			record.State = animax.JobDone
+		case record.State != animax.JobRunning:
+			record.State = animax.JobRunning
+		}
+	})
+	if err != nil {
+		animax.Logger.Warnf("Unable to record upload of job %s | Error: %s", upload.JobID, err)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,89 @@
+package animax_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pichan321/animax"
+	util "github.com/pichan321/animax/utilities"
+)
+
+// graphAPI stands in for the Graph API, acknowledging transfers by chunks of 30 bytes.
+type graphAPI struct {
+	mu       sync.Mutex
+	phases   []string
+	received map[string][]byte // start_offset -> chunk
+}
+
+func (api *graphAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	phase := req.URL.Query().Get("upload_phase")
+	body := `{"success": true}`
+	if phase == "" {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			return nil, err
+		}
+		phase = req.FormValue("upload_phase")
+		file, _, err := req.FormFile("video_file_chunk")
+		if err != nil {
+			return nil, err
+		}
+		chunk, _ := io.ReadAll(file)
+		offset := req.FormValue("start_offset")
+		api.received[offset] = chunk
+		next := map[string]string{"40": `{"start_offset": "70", "end_offset": "100"}`, "70": `{"start_offset": "100", "end_offset": "100"}`}[offset]
+		if next == "" {
+			next = `{"error": "unexpected offset ` + offset + `"}`
+		}
+		body = next
+	}
+	api.phases = append(api.phases, phase)
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func TestVideoUploadResumesFromTheStoredOffset(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	video := filepath.Join(dir, "video.mp4")
+	if err := os.WriteFile(video, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := animax.NewFileJobStore(filepath.Join(dir, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an earlier attempt had 40 bytes acknowledged before it stopped
+	store.Update("upload", func(record *animax.JobRecord) {
+		record.Upload = &animax.UploadSession{SessionID: "session", FilePath: video, FileSize: 100, StartOffset: 40, EndOffset: 70}
+	})
+
+	api := &graphAPI{received: map[string][]byte{}}
+	upload := util.PageUpload{FilePath: video, Title: "Part 1", Token: "token", PageId: "42", Store: store, JobID: "upload", Client: &http.Client{Transport: api}}
+
+	steps, err := upload.VideoSteps()
+	if err != nil || !strings.Contains(steps[0], "resume upload session session") || !strings.Contains(steps[1], "start_offset=40") {
+		t.Errorf("got steps %q (%v), want the stored session resumed at 40", steps, err)
+	}
+
+	if err := util.UploadToFacebookVideoPage(upload); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(api.phases, " "); got != "transfer transfer finish" {
+		t.Errorf("made the requests %q, want two transfers and the finish without starting a session", got)
+	}
+	if !bytes.Equal(api.received["40"], content[40:70]) || !bytes.Equal(api.received["70"], content[70:]) {
+		t.Errorf("received %q and %q, want bytes 40 to 70 then 70 to 100", api.received["40"], api.received["70"])
+	}
+	record, err := store.Load("upload")
+	if err != nil || record.Upload != nil || record.State != animax.JobDone {
+		t.Errorf("stored %+v (%v), want the published upload forgotten", record, err)
+	}
+}
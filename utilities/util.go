@@ -1,16 +1,25 @@
 package animax
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pichan321/animax"
 )
 
+// runFFmpeg runs ffmpeg through animax.DefaultExecutor and returns its stderr, where ffmpeg writes its logs.
+func runFFmpeg(ctx context.Context, args ...string) ([]byte, error) {
+	animax.Logger.Infoln("Command to be executed: ffmpeg " + strings.Join(args, " "))
+	_, stderr, err := animax.DefaultExecutor.Run(ctx, "ffmpeg", args)
+	return stderr, err
+}
+
 func VerifyFilePath(filePath string) (err error) {
 	file, err := os.Stat(filePath)
 	if err != nil {
@@ -28,6 +37,13 @@ func VerifyFilePath(filePath string) (err error) {
 	Returns nil if successful and an error otherwise.
 ***/
 func AddOverlayBackground(video animax.Video, outputPath string) (err error) {
+	return AddOverlayBackgroundContext(context.Background(), video, outputPath)
+}
+
+/***
+	Same as AddOverlayBackground but kills ffmpeg and returns ctx.Err() once ctx is cancelled.
+***/
+func AddOverlayBackgroundContext(ctx context.Context, video animax.Video, outputPath string) (err error) {
 	err = VerifyFilePath(video.FilePath)
 	if err != nil {
 		return err
@@ -39,9 +55,12 @@ func AddOverlayBackground(video animax.Video, outputPath string) (err error) {
 	}
 	
 	animax.Logger.Info(fmt.Sprintf(`Adding overlay background for %s | Output: %s`, video.FilePath, outputPath))
-	cmd := exec.Command("ffmpeg", "-i", video.FilePath, "-i", video.FilePath, "-filter_complex", "[1]scale=1080:600[vid]; [0]scale=1080:1920[img]; [img][vid] overlay=(W-w)/2:(H-h)/2", "-c:v", "libx264", "-c:a", "aac", outputPath)
-	output, err := cmd.CombinedOutput()
+	output, err := runFFmpeg(ctx, "-i", video.FilePath, "-i", video.FilePath, "-filter_complex", "[1]scale=1080:600[vid]; [0]scale=1080:1920[img]; [img][vid] overlay=(W-w)/2:(H-h)/2", "-c:v", "libx264", "-c:a", "aac", outputPath)
 	if err != nil {
+		if ctx.Err() != nil {
+			os.Remove(outputPath)
+			return ctx.Err()
+		}
 		animax.Logger.Errorf("Failed to add background | Error: %s", string(output))
 		return err
 	}
@@ -54,6 +73,13 @@ func AddOverlayBackground(video animax.Video, outputPath string) (err error) {
 	Returns nil if successful and an error otherwise.
 ***/
 func AddOverlayBackgroundAndLogo(video animax.Video, logoPath string, outputPath string) (err error) {
+	return AddOverlayBackgroundAndLogoContext(context.Background(), video, logoPath, outputPath)
+}
+
+/***
+	Same as AddOverlayBackgroundAndLogo but kills ffmpeg and returns ctx.Err() once ctx is cancelled.
+***/
+func AddOverlayBackgroundAndLogoContext(ctx context.Context, video animax.Video, logoPath string, outputPath string) (err error) {
 	err = VerifyFilePath(logoPath)
 	if err != nil {
 		return err
@@ -68,9 +94,12 @@ func AddOverlayBackgroundAndLogo(video animax.Video, logoPath string, outputPath
 		os.Remove(outputPath)
 	}
 	animax.Logger.Info(fmt.Sprintf(`Adding overlay background with logo for %s | Output: %s`, video.FilePath, outputPath))
-	cmd := exec.Command("ffmpeg", "-i", video.FilePath, "-i", video.FilePath, "-i", logoPath, "-filter_complex", "[1]scale=1080:600[vid]; [0]crop=540:960:(in_w-540)/2:(in_h-960)/2,scale=1080:1920[img]; [img]boxblur=15[blurred]; [blurred][vid]overlay=(W-w)/2:(H-h)/2[with_logo]; [2:v]scale=320:220[logo_resized]; [with_logo][logo_resized]overlay=20:H-h-300", "-c:v", "libx264", "-c:a", "aac", outputPath)
-	output, err := cmd.CombinedOutput()
+	output, err := runFFmpeg(ctx, "-i", video.FilePath, "-i", video.FilePath, "-i", logoPath, "-filter_complex", "[1]scale=1080:600[vid]; [0]crop=540:960:(in_w-540)/2:(in_h-960)/2,scale=1080:1920[img]; [img]boxblur=15[blurred]; [blurred][vid]overlay=(W-w)/2:(H-h)/2[with_logo]; [2:v]scale=320:220[logo_resized]; [with_logo][logo_resized]overlay=20:H-h-300", "-c:v", "libx264", "-c:a", "aac", outputPath)
 	if err != nil {
+		if ctx.Err() != nil {
+			os.Remove(outputPath)
+			return ctx.Err()
+		}
 		animax.Logger.Errorf("Failed to add background | Error: %s", string(output))
 		return err
 	}
@@ -83,6 +112,13 @@ func AddOverlayBackgroundAndLogo(video animax.Video, logoPath string, outputPath
 	Returns nil if successful and an error otherwise.
 ***/
 func ConcatenateVideos(videos []animax.Video, encode bool, outputPath string) (err error) {
+	return ConcatenateVideosContext(context.Background(), videos, encode, outputPath)
+}
+
+/***
+	Same as ConcatenateVideos but kills ffmpeg and returns ctx.Err() once ctx is cancelled.
+***/
+func ConcatenateVideosContext(ctx context.Context, videos []animax.Video, encode bool, outputPath string) (err error) {
 	err = VerifyFilePath(outputPath)
 	if err == nil {
 		os.Remove(outputPath)
@@ -102,18 +138,24 @@ func ConcatenateVideos(videos []animax.Video, encode bool, outputPath string) (e
 	inputTextFile.Close()
 
 	if encode {
-		cmd := exec.Command("ffmpeg", "-f", "concat", "-i", inputTextFileName, "-c:v", "libx264", "-c:a", "aac", outputPath)
-		output, err := cmd.CombinedOutput()
+		output, err := runFFmpeg(ctx, "-f", "concat", "-i", inputTextFileName, "-c:v", "libx264", "-c:a", "aac", outputPath)
 		if err != nil {
+			if ctx.Err() != nil {
+				os.Remove(outputPath)
+				return ctx.Err()
+			}
 			animax.Logger.Info(fmt.Sprintf(`Error: %s`, string(output)))
 			return err
 		}
 		return nil
 	}
 
-	cmd := exec.Command("ffmpeg", "-f", "concat", "-i", inputTextFileName, "-c:v", "copy", "-c:a", "copy", outputPath)
-	output, err := cmd.CombinedOutput()
+	output, err := runFFmpeg(ctx, "-f", "concat", "-i", inputTextFileName, "-c:v", "copy", "-c:a", "copy", outputPath)
 	if err != nil {
+		if ctx.Err() != nil {
+			os.Remove(outputPath)
+			return ctx.Err()
+		}
 		animax.Logger.Info(fmt.Sprintf(`Error: %s`, string(output)))
 		return err
 	}
@@ -121,6 +163,13 @@ func ConcatenateVideos(videos []animax.Video, encode bool, outputPath string) (e
 }
 
 func ConcatenateVideosFromDir(directoryPath string, encode bool, outputPath string) error {
+	return ConcatenateVideosFromDirContext(context.Background(), directoryPath, encode, outputPath)
+}
+
+/***
+	Same as ConcatenateVideosFromDir but kills ffmpeg and returns ctx.Err() once ctx is cancelled.
+***/
+func ConcatenateVideosFromDirContext(ctx context.Context, directoryPath string, encode bool, outputPath string) error {
 	dir, err := os.Stat(directoryPath); 
 	if os.IsNotExist(err) {
 		animax.Logger.Errorf("%s does not exist", directoryPath)
@@ -153,7 +202,7 @@ func ConcatenateVideosFromDir(directoryPath string, encode bool, outputPath stri
 		}
 	}
 
-	err = ConcatenateVideos(videosInDir, false, outputPath)
+	err = ConcatenateVideosContext(ctx, videosInDir, encode, outputPath)
 	if err != nil {
 		animax.Logger.Errorf("Error during concatenation phase | %s", err)
 		return err
@@ -163,12 +212,31 @@ func ConcatenateVideosFromDir(directoryPath string, encode bool, outputPath stri
 }
 
 func TrimNoEncode(video animax.Video, startTime int64, endTime int64, outputString string) (animax.Video, error) {
-	newStart := video.SeekFrame(startTime)
-	if newStart == -1 {newStart = float64(startTime)}
-	cmd := exec.Command("ffmpeg", "-ss", fmt.Sprintf("%.5f", newStart), "-i", video.FilePath, "-to", fmt.Sprintf("%.5f", float64(endTime) - float64(startTime)), "-c", "copy", "-y", outputString)
-	animax.Logger.Infoln("Command to be executed: " + cmd.String())
-	_, err := cmd.CombinedOutput()
+	return TrimNoEncodeContext(context.Background(), video, startTime, endTime, outputString)
+}
+
+func TrimNoEncodeContext(ctx context.Context, video animax.Video, startTime int64, endTime int64, outputString string) (animax.Video, error) {
+	return TrimNoEncodeRangeContext(ctx, video, time.Duration(startTime) * time.Second, time.Duration(endTime) * time.Second, outputString)
+}
+
+/***
+	Same as TrimNoEncode with sub-second precision.
+***/
+func TrimNoEncodeRange(video animax.Video, start time.Duration, end time.Duration, outputString string) (animax.Video, error) {
+	return TrimNoEncodeRangeContext(context.Background(), video, start, end, outputString)
+}
+
+func TrimNoEncodeRangeContext(ctx context.Context, video animax.Video, start time.Duration, end time.Duration, outputString string) (animax.Video, error) {
+	newStart := copyStart(ctx, video, start)
+	if err := ctx.Err(); err != nil {
+		return animax.Video{}, err
+	}
+	_, err := runFFmpeg(ctx, "-ss", fmt.Sprintf("%.5f", newStart), "-i", video.FilePath, "-to", fmt.Sprintf("%.5f", end.Seconds() - newStart), "-c", "copy", "-y", outputString)
 	if err != nil {
+		if ctx.Err() != nil {
+			os.Remove(outputString)
+			return animax.Video{}, ctx.Err()
+		}
 		return animax.Video{}, errors.New("unable to trim the video")
 	}
 
@@ -179,28 +247,162 @@ func TrimNoEncode(video animax.Video, startTime int64, endTime int64, outputStri
 	return outputVideo, nil
 }
 
+// input seeking with stream copy starts at the keyframe before start, snap to it so the clip keeps its length
+func copyStart(ctx context.Context, video animax.Video, start time.Duration) float64 {
+	if keyframe, err := video.KeyframeBeforeContext(ctx, start); err == nil {
+		return keyframe.Seconds()
+	}
+	return video.SeekFrameAt(start)
+}
+
+/***
+	Cuts exactly from start to end while re-encoding as little as possible: only the fragment between start and the next keyframe
+	is re-encoded, with the codecs of the source, and the rest is stream copied from that keyframe on.
+***/
+func SmartTrim(video animax.Video, start time.Duration, end time.Duration, outputPath string) (animax.Video, error) {
+	return SmartTrimContext(context.Background(), video, start, end, outputPath)
+}
+
+func SmartTrimContext(ctx context.Context, video animax.Video, start time.Duration, end time.Duration, outputPath string) (animax.Video, error) {
+	if start >= end {
+		return animax.Video{}, errors.New("start time must be before end time")
+	}
+
+	keyframe, err := video.KeyframeAfterContext(ctx, start)
+	if ctx.Err() != nil {
+		return animax.Video{}, ctx.Err()
+	}
+	if err != nil || keyframe >= end {
+		// no keyframe inside the section, the whole section has to be re-encoded
+		return reencodeSection(ctx, video, start, end, outputPath)
+	}
+	if keyframe-start < time.Millisecond {
+		return TrimNoEncodeRangeContext(ctx, video, start, end, outputPath)
+	}
+
+	workingDir := uuid.New().String()
+	os.Mkdir(workingDir, os.ModePerm)
+	defer os.RemoveAll(workingDir)
+
+	head, err := reencodeSection(ctx, video, start, keyframe, fmt.Sprintf(`%s/head%s`, workingDir, video.GetExtension()))
+	if err != nil {
+		return animax.Video{}, err
+	}
+
+	// input seeking with stream copy starts at the keyframe before the seek, so a seek rounded down below the keyframe
+	// would start one GOP early; a millisecond past it is still before the next frame
+	tailPath := fmt.Sprintf(`%s/tail%s`, workingDir, video.GetExtension())
+	seek := keyframe + time.Millisecond
+	_, err = runFFmpeg(ctx, "-ss", fmt.Sprintf("%.6f", seek.Seconds()), "-i", video.FilePath, "-t", fmt.Sprintf("%.6f", (end - seek).Seconds()), "-c", "copy", "-avoid_negative_ts", "make_zero", "-y", tailPath)
+	if err != nil {
+		if ctx.Err() != nil {
+			return animax.Video{}, ctx.Err()
+		}
+		return animax.Video{}, errors.New("unable to copy the video after the keyframe")
+	}
+	tail, err := animax.LoadVideo(tailPath)
+	if err != nil {
+		return animax.Video{}, err
+	}
+
+	err = ConcatenateVideosContext(ctx, []animax.Video{head, tail}, false, outputPath)
+	if err != nil {
+		return animax.Video{}, err
+	}
+	return animax.LoadVideo(outputPath)
+}
+
+// reencodeSection re-encodes start to end with the codecs of the source so it can be concatenated with stream copied parts.
+func reencodeSection(ctx context.Context, video animax.Video, start time.Duration, end time.Duration, outputPath string) (animax.Video, error) {
+	args := []string{"-ss", fmt.Sprintf("%.5f", start.Seconds()), "-i", video.FilePath, "-t", fmt.Sprintf("%.5f", (end - start).Seconds())}
+	if video.Info != nil {
+		if stream := video.Info.VideoStream(); stream != nil {
+			args = append(args, "-c:v", matchingEncoder(stream.Codec))
+			if stream.PixelFormat != "" {args = append(args, "-pix_fmt", stream.PixelFormat)}
+			// the head is concatenated with the copied tail, its parameter sets have to match the stream of the source
+			switch stream.Codec {
+			case "h264":
+				if stream.Profile != "" {args = append(args, "-profile:v", h264Profile(stream.Profile))}
+				if stream.Level > 0 {args = append(args, "-level", fmt.Sprintf("%d.%d", stream.Level/10, stream.Level%10))}
+			case "hevc":
+				if stream.Profile != "" {args = append(args, "-profile:v", strings.ToLower(strings.ReplaceAll(stream.Profile, " ", "")))}
+			}
+		}
+		if stream := video.Info.AudioStream(); stream != nil {
+			args = append(args, "-c:a", matchingEncoder(stream.Codec))
+			if stream.SampleRate > 0 {args = append(args, "-ar", fmt.Sprintf("%d", stream.SampleRate))}
+			if stream.Channels > 0 {args = append(args, "-ac", fmt.Sprintf("%d", stream.Channels))}
+		}
+	}
+	args = append(args, "-y", outputPath)
+
+	output, err := runFFmpeg(ctx, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return animax.Video{}, ctx.Err()
+		}
+		animax.Logger.Errorf("Unable to re-encode section | Error: %s", string(output))
+		return animax.Video{}, errors.New("unable to re-encode the section")
+	}
+	return animax.LoadVideo(outputPath)
+}
+
+// matchingEncoder returns the encoder producing streams of the given codec name.
+func matchingEncoder(codec string) string {
+	switch codec {
+	case "h264":
+		return "libx264"
+	case "hevc":
+		return "libx265"
+	case "vp8":
+		return "libvpx"
+	case "vp9":
+		return "libvpx-vp9"
+	case "av1":
+		return "libaom-av1"
+	case "mp3":
+		return "libmp3lame"
+	case "opus":
+		return "libopus"
+	case "vorbis":
+		return "libvorbis"
+	}
+	return codec
+}
+
+func h264Profile(profile string) string {
+	switch profile {
+	case "Constrained Baseline", "Baseline":
+		return "baseline"
+	case "High 10":
+		return "high10"
+	case "High 4:2:2":
+		return "high422"
+	case "High 4:4:4 Predictive":
+		return "high444"
+	}
+	return strings.ToLower(profile)
+}
+
 func Skipper(video animax.Video, skipDuration float64, skipInterval float64, outputPath string) error {
-	nextFrameToSkip := 0.0
+	return SkipperContext(context.Background(), video, skipDuration, skipInterval, outputPath)
+}
+
+func SkipperContext(ctx context.Context, video animax.Video, skipDuration float64, skipInterval float64, outputPath string) error {
 	workingDir := uuid.New().String()
 
 	if _, err := os.Stat(workingDir); os.IsNotExist(err) {
 		os.Mkdir(workingDir, os.ModePerm)
 	}
-	videoDuration := video.Duration
+	defer os.RemoveAll(workingDir)
 	originalVideoPath := video.FilePath
-	index := 0
 	clipsToConcat := []animax.Video{}
 	animax.Logger.Infof("Video: %s | Path: %s | Initiating skipper", video.FileName, video.FilePath)
-	for i := 0.0; i < float64(videoDuration); i++ {
-		currentFrame := i
-
-		if (currentFrame >= float64(videoDuration)) || (nextFrameToSkip + skipDuration > float64(videoDuration)) || (nextFrameToSkip >= float64(videoDuration)) {break}
-		if currentFrame < nextFrameToSkip {continue}
-
-		start := nextFrameToSkip
-		end := nextFrameToSkip + skipInterval
-
-		if end >= float64(videoDuration) {end = float64(videoDuration)}
+	for index, section := range skipperSections(video, skipDuration, skipInterval) {
+		if err := ctx.Err(); err != nil {
+			animax.Logger.Warnf("Video: %s | Path: %s | Skipper cancelled", video.FileName, video.FilePath)
+			return err
+		}
 
 		// clipUuid := uuid.New().String()
 		clipName := fmt.Sprintf(`%s/%d.mp4`, workingDir, index)
@@ -211,39 +413,203 @@ func Skipper(video animax.Video, skipDuration float64, skipInterval float64, out
 			break
 		}
 
-		video, _ = TrimNoEncode(originalVideo, int64(start), int64(end), clipName)
+		video, err = TrimNoEncodeContext(ctx, originalVideo, section.StartTime, section.EndTime, clipName)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// video = originalVideo.Trim(int64(start), int64(end)).Render(clipName, animax.VIDEO_ENCODINGS.Best)
 		clipsToConcat = append(clipsToConcat, video)
-		animax.Logger.Infof("Video: %s | Start: %f | End: %f |Path: %s | Subclip %s generated", video.FileName, start, end, video.FilePath, clipName)
-		index++
-		nextFrameToSkip = end + skipDuration
-
-		if nextFrameToSkip > float64(videoDuration) {
-			animax.Logger.Infof("Video: %s | Path: %s | Exiting skipper loop", originalVideo.FileName, originalVideo.FilePath)
-			break
-		}
+		animax.Logger.Infof("Video: %s | Start: %d | End: %d |Path: %s | Subclip %s generated", video.FileName, section.StartTime, section.EndTime, video.FilePath, clipName)
 	}
 
 	animax.Logger.Infof("Video: %s | Path: %s | Concatenating all clips in working directory %s", video.FileName, video.FilePath, workingDir)
-	err := ConcatenateVideos(clipsToConcat, true, outputPath)
+	err := ConcatenateVideosContext(ctx, clipsToConcat, true, outputPath)
 	if err != nil {
 		animax.Logger.Error("Error during concatenation")
 		return err
 	}
 	animax.Logger.Infof("Video: %s | Path: %s | Completed concatenation in working directory %s", video.FileName, video.FilePath, workingDir)
 	animax.Logger.Infof("Video: %s | Path: %s | Cleaning up working directory %s", video.FileName, video.FilePath, workingDir)
-	defer os.RemoveAll(workingDir)
 	return nil
 }
 
-func ExtractAudio(videoPath string, outputPath string) error {
-	return nil
+// skipperSections returns the parts of video Skipper keeps: skipInterval seconds, then a gap of skipDuration seconds, repeatedly.
+func skipperSections(video animax.Video, skipDuration float64, skipInterval float64) []animax.TrimSection {
+	sections := []animax.TrimSection{}
+	nextFrameToSkip := 0.0
+	videoDuration := float64(video.Duration)
+	for currentFrame := 0.0; currentFrame < videoDuration; currentFrame++ {
+		if (nextFrameToSkip + skipDuration > videoDuration) || (nextFrameToSkip >= videoDuration) {break}
+		if currentFrame < nextFrameToSkip {continue}
+
+		start := nextFrameToSkip
+		end := nextFrameToSkip + skipInterval
+		if end >= videoDuration {end = videoDuration}
+
+		sections = append(sections, animax.TrimSection{StartTime: int64(start), EndTime: int64(end)})
+		nextFrameToSkip = end + skipDuration
+		if nextFrameToSkip > videoDuration {break}
+	}
+	return sections
+}
+
+/***
+	SkipperSubtitles writes the subtitles of the video Skipper renders with the same arguments, e.g. to burn them or mux them
+	into the result. subtitles are timed to video. Every clip starts at the keyframe Skipper cuts it from.
+***/
+func SkipperSubtitles(video animax.Video, subtitles animax.Subtitles, skipDuration float64, skipInterval float64, outputPath string) error {
+	sections := skipperSections(video, skipDuration, skipInterval)
+	for i, section := range sections {
+		start, end := section.Range()
+		sections[i] = animax.TrimSection{Start: time.Duration(copyStart(context.Background(), video, start) * float64(time.Second)), End: end}
+	}
+	return subtitles.Keep(sections).Write(outputPath)
+}
+
+type ExtractAudioOptions struct {
+	Track    int    // position of the stream among the audio streams, starting at 0
+	Language string // picks the first audio stream with this language tag instead of Track, e.g. "eng"
+	Codec    string // transcodes with this encoder, e.g. "libmp3lame"; the stream is copied when empty and the container allows it
+	Bitrate  string // e.g. "192k", only used when transcoding
+}
+
+// containers lists, per extension, the codecs it can hold without transcoding and the encoder used when it cannot.
+var containers = map[string]struct {
+	codecs  []string
+	encoder string
+}{
+	".mp3":  {[]string{"mp3"}, "libmp3lame"},
+	".aac":  {[]string{"aac"}, "aac"},
+	".m4a":  {[]string{"aac", "alac"}, "aac"},
+	".wav":  {[]string{"pcm_"}, "pcm_s16le"},
+	".flac": {[]string{"flac"}, "flac"},
+	".ogg":  {[]string{"vorbis", "opus", "flac"}, "libvorbis"},
+	".opus": {[]string{"opus"}, "libopus"},
+	".mka":  {[]string{""}, ""}, // Matroska holds any codec
+}
+
+// containerFor returns the extension a stream of codec can be copied into.
+func containerFor(codec string) string {
+	switch {
+	case codec == "aac":
+		return ".m4a"
+	case codec == "vorbis":
+		return ".ogg"
+	case codec == "mp3", codec == "flac", codec == "opus":
+		return "." + codec
+	case strings.HasPrefix(codec, "pcm_"):
+		return ".wav"
+	}
+	return ".mka"
+}
+
+func canCopy(codec string, extension string) bool {
+	container, ok := containers[extension]
+	if !ok {
+		return false
+	}
+	for _, prefix := range container.codecs {
+		if strings.HasPrefix(codec, prefix) {return true}
+	}
+	return false
+}
+
+/***
+	Pulls an audio stream out of a video. When the video has several audio streams, options picks one by position or language.
+	Without a codec the stream is copied if the container of outputPath can hold it, and transcoded to the usual codec of
+	the container otherwise. An outputPath without extension gets the container matching the stream, so it can always be copied.
+***/
+func ExtractAudio(videoPath string, outputPath string, options ExtractAudioOptions) (animax.Audio, error) {
+	return ExtractAudioContext(context.Background(), videoPath, outputPath, options)
+}
+
+func ExtractAudioContext(ctx context.Context, videoPath string, outputPath string, options ExtractAudioOptions) (animax.Audio, error) {
+	if err := VerifyFilePath(videoPath); err != nil {
+		return animax.Audio{}, err
+	}
+	info, err := animax.ProbeContext(ctx, videoPath)
+	if err != nil {
+		if ctx.Err() != nil {
+			return animax.Audio{}, ctx.Err()
+		}
+		return animax.Audio{}, err
+	}
+
+	tracks := info.StreamsOfType("audio")
+	index := options.Track
+	if options.Language != "" {
+		index = -1
+		for i, track := range tracks {
+			if strings.EqualFold(track.Language, options.Language) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return animax.Audio{}, fmt.Errorf("%s has no audio track in %s", videoPath, options.Language)
+		}
+	}
+	if index < 0 || index >= len(tracks) {
+		return animax.Audio{}, fmt.Errorf("%s has no audio track %d, it has %d", videoPath, index, len(tracks))
+	}
+	track := tracks[index]
+
+	extension := strings.ToLower(filepath.Ext(outputPath))
+	if extension == "" {
+		extension = containerFor(track.Codec)
+		outputPath += extension
+	}
+	if _, ok := containers[extension]; !ok {
+		return animax.Audio{}, fmt.Errorf("outputPath: %s | audio format %s is not supported", outputPath, extension)
+	}
+
+	args := []string{"-i", videoPath, "-map", fmt.Sprintf("0:a:%d", index), "-vn"}
+	switch {
+	case options.Codec != "":
+		args = append(args, "-c:a", options.Codec)
+	case canCopy(track.Codec, extension):
+		args = append(args, "-c:a", "copy")
+	default:
+		animax.Logger.Infof("Video: %s | %s audio cannot be copied into %s, transcoding with %s", videoPath, track.Codec, extension, containers[extension].encoder)
+		args = append(args, "-c:a", containers[extension].encoder)
+	}
+	if options.Bitrate != "" && !containsString(args, "copy") {
+		args = append(args, "-b:a", options.Bitrate)
+	}
+	args = append(args, "-y", outputPath)
+
+	output, err := runFFmpeg(ctx, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			os.Remove(outputPath)
+			return animax.Audio{}, ctx.Err()
+		}
+		animax.Logger.Errorf("Video: %s | Unable to extract audio | %s", videoPath, string(output))
+		return animax.Audio{}, err
+	}
+	return animax.LoadAudio(outputPath)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {return true}
+	}
+	return false
 }
 
 func TakeScreenshot(videoPath string, time float64, outputPath string) error {
-	cmd := exec.Command("ffmpeg", "-i", videoPath, "-ss", fmt.Sprintf("%f", time), "-frames:v", "1", "-y", outputPath)
-	output, err := cmd.CombinedOutput()
+	return TakeScreenshotContext(context.Background(), videoPath, time, outputPath)
+}
+
+/***
+	Same as TakeScreenshot but kills ffmpeg and returns ctx.Err() once ctx is cancelled.
+***/
+func TakeScreenshotContext(ctx context.Context, videoPath string, time float64, outputPath string) error {
+	output, err := runFFmpeg(ctx, "-i", videoPath, "-ss", fmt.Sprintf("%f", time), "-frames:v", "1", "-y", outputPath)
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		animax.Logger.Infof("%s\n", string(output))
 		return err
 	}
@@ -0,0 +1,183 @@
+package animax
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// subtitleTrackFlag marks the effects added by AddSubtitleTrack. It is not an ffmpeg flag, collapseStages turns it into an input and a map.
+const subtitleTrackFlag = "-subtitle_track"
+
+/*
+	SubtitleStyle overrides the style of burned subtitles. Colors are "#RRGGBB", or "#RRGGBBAA" with an opacity.
+	The zero value keeps the styles of the file, or the defaults of ffmpeg for SRT and WebVTT.
+*/
+type SubtitleStyle struct {
+	FontName     string // name of an installed font or of a font in FontsDir
+	FontsDir     string
+	FontSize     int
+	Color        string
+	OutlineColor string
+	Outline      int // width of the outline
+	Shadow       int // depth of the shadow
+	Bold         bool
+	Position     string // one of TEXT_POSITIONS
+	Margin       int    // vertical distance to the edge of the frame
+}
+
+// subtitleTrack is an extra input muxed as a subtitle stream.
+type subtitleTrack struct {
+	path     string
+	language string
+}
+
+// ASS alignments are laid out like a numeric keypad
+var subtitleAlignments = map[string]int{
+	TEXT_POSITIONS.BottomLeft: 1, TEXT_POSITIONS.BottomCenter: 2, TEXT_POSITIONS.BottomRight: 3,
+	TEXT_POSITIONS.CenterLeft: 4, TEXT_POSITIONS.Center: 5, TEXT_POSITIONS.CenterRight: 6,
+	TEXT_POSITIONS.TopLeft: 7, TEXT_POSITIONS.TopCenter: 8, TEXT_POSITIONS.TopRight: 9,
+}
+
+/*
+	BurnSubtitles draws an SRT, WebVTT or ASS file onto the video with the subtitles filter, or the ass filter for ASS files
+	without style overrides. The file is timed to the source video: trims anywhere in the chain keep every cue on the frames it
+	belongs to.
+
+		clip := video.TrimRange(60*time.Second, 90*time.Second).BurnSubtitles("episode.srt", animax.SubtitleStyle{
+			FontName: "Inter", FontSize: 22, Outline: 2, Position: animax.TEXT_POSITIONS.BottomCenter, Margin: 60,
+		})
+*/
+func (video *Video) BurnSubtitles(subtitlesPath string, style SubtitleStyle) (modifiedVideo *Video) {
+	format := subtitleFormat(subtitlesPath)
+	if format == "" {
+		Logger.Errorf("subtitlesPath: %s | Subtitle format is not supported", subtitlesPath)
+		return &Video{}
+	}
+	if _, err := os.Stat(subtitlesPath); err != nil {
+		Logger.Errorf("subtitlesPath: %s does not exist", subtitlesPath)
+		return &Video{}
+	}
+	forceStyle, err := style.forceStyle()
+	if err != nil {
+		Logger.Errorf("subtitlesPath: %s | %s", subtitlesPath, err)
+		return &Video{}
+	}
+
+	filter := "subtitles"
+	if format == SUBTITLE_FORMATS.ASS && forceStyle == "" {filter = "ass"}
+	options := [][2]string{{"filename", subtitlesPath}}
+	if style.FontsDir != "" {
+		options = append(options, [2]string{"fontsdir", style.FontsDir})
+	}
+	if forceStyle != "" {
+		options = append(options, [2]string{"force_style", forceStyle})
+	}
+	return video.withEffect("-filter_complex",
+		subArg{
+			Key: "subtitles",
+			Value: filter + "=" + joinFilterOptions(options),
+		})
+}
+
+// forceStyle returns the ASS style overrides of the subtitles filter.
+func (style SubtitleStyle) forceStyle() (string, error) {
+	overrides := []string{}
+	if style.FontName != "" {
+		overrides = append(overrides, "FontName="+style.FontName)
+	}
+	if style.FontSize > 0 {
+		overrides = append(overrides, fmt.Sprintf("FontSize=%d", style.FontSize))
+	}
+	for _, color := range []struct{ name, value string }{{"PrimaryColour", style.Color}, {"OutlineColour", style.OutlineColor}} {
+		if color.value == "" {continue}
+		converted, err := assColor(color.value)
+		if err != nil {
+			return "", err
+		}
+		overrides = append(overrides, color.name+"="+converted)
+	}
+	if style.Outline > 0 {
+		overrides = append(overrides, fmt.Sprintf("Outline=%d", style.Outline))
+	}
+	if style.Shadow > 0 {
+		overrides = append(overrides, fmt.Sprintf("Shadow=%d", style.Shadow))
+	}
+	if style.Bold {
+		overrides = append(overrides, "Bold=1")
+	}
+	if style.Position != "" {
+		alignment, ok := subtitleAlignments[style.Position]
+		if !ok {
+			return "", fmt.Errorf("unknown subtitle position %q", style.Position)
+		}
+		overrides = append(overrides, fmt.Sprintf("Alignment=%d", alignment))
+	}
+	if style.Margin > 0 {
+		overrides = append(overrides, fmt.Sprintf("MarginV=%d", style.Margin))
+	}
+	return strings.Join(overrides, ","), nil
+}
+
+// assColor converts "#RRGGBB" or "#RRGGBBAA" to the &HAABBGGRR colors of ASS, whose alpha is a transparency.
+func assColor(color string) (string, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return "", fmt.Errorf("invalid color %q, expected #RRGGBB or #RRGGBBAA", color)
+	}
+	if _, err := strconv.ParseUint(hex, 16, 32); err != nil {
+		return "", fmt.Errorf("invalid color %q, expected #RRGGBB or #RRGGBBAA", color)
+	}
+	alpha := uint64(0)
+	if len(hex) == 8 {
+		opacity, _ := strconv.ParseUint(hex[6:], 16, 8)
+		alpha = 255 - opacity
+	}
+	return strings.ToUpper(fmt.Sprintf("&H%02x%s%s%s", alpha, hex[4:6], hex[2:4], hex[0:2])), nil
+}
+
+/*
+	AddSubtitleTrack muxes an SRT, WebVTT or ASS file into the video as a subtitle stream viewers can turn on, tagged with
+	language, e.g. "eng", when it is not empty. Subtitles are converted to mov_text in MP4 and MOV outputs, to WebVTT in WebM
+	outputs and copied in MKV outputs; rendering them to an AVI output fails.
+	Like BurnSubtitles the file is timed to the source video, trims cut and shift the cues with the rest of the video.
+*/
+func (video *Video) AddSubtitleTrack(subtitlesPath string, language string) (modifiedVideo *Video) {
+	if subtitleFormat(subtitlesPath) == "" {
+		Logger.Errorf("subtitlesPath: %s | Subtitle format is not supported", subtitlesPath)
+		return &Video{}
+	}
+	if _, err := os.Stat(subtitlesPath); err != nil {
+		Logger.Errorf("subtitlesPath: %s does not exist", subtitlesPath)
+		return &Video{}
+	}
+	modifiedVideo = video.withEffect(subtitleTrackFlag,
+		subArg{
+			Key: "subtitle_track",
+			Value: subtitlesPath,
+		})
+	modifiedVideo.effects.subtitle = &subtitleTrack{path: subtitlesPath, language: language}
+	return modifiedVideo
+}
+
+// hasSubtitleTracks reports whether AddSubtitleTrack was applied to the video.
+func (video Video) hasSubtitleTracks() bool {
+	for _, effect := range video.effects.list() {
+		if effect.subtitle != nil {return true}
+	}
+	return false
+}
+
+// subtitleCodec returns the subtitle encoder for a container, "" when it cannot hold subtitles.
+func subtitleCodec(extension string) string {
+	switch strings.ToLower(extension) {
+	case ".mp4", ".mov", ".m4v":
+		return "mov_text"
+	case ".webm":
+		return "webvtt"
+	case ".avi":
+		return ""
+	}
+	return "copy"
+}
@@ -1,14 +1,14 @@
 package animax
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
 )
 
 type Video struct {
@@ -19,17 +19,35 @@ type Video struct {
 	Duration int64
 	AspectRatio string
 	Format string
-	args Args
+	effects *effect
 	IsMuted bool
+	Info *MediaInfo
+	keyframes *keyframeIndex
+	audioStream string // set by SelectAudioTrack and SelectAudioLanguage
 }
 
 type TrimSection struct {
 	StartTime int64
 	EndTime int64
 	OutputName string
+
+	// Start and End take precedence over StartTime and EndTime when End is set.
+	Start time.Duration
+	End time.Duration
+}
+
+// Range returns the bounds of the section with sub-second precision.
+func (section TrimSection) Range() (start time.Duration, end time.Duration) {
+	if section.End > 0 {
+		return section.Start, section.End
+	}
+	return time.Duration(section.StartTime) * time.Second, time.Duration(section.EndTime) * time.Second
 }
 
-var videoExtensions = []string{".mp4", ".avi", ".mov", ".mkv"}
+// below this bitrate a render is not worth attempting
+const minimumVideoBitrate = 100 * 1000
+
+var videoExtensions = []string{".mp4", ".avi", ".mov", ".mkv", ".webm"}
 var VIDEO_ENCODINGS = struct {
 	Best string
 	Efficient string
@@ -74,23 +92,19 @@ func calculatePts(n int, fps float64) float64 {
 	return float64(n) / fps
 }
 
+// searchPts snaps start to the timestamp of the closest frame, assuming a constant frame rate.
 func searchPts(fps float64, frames int, start float64) float64 {
-	low, high := 1, frames
-	for low <= high {
-		mid := (low + high) / 2
-		pts := calculatePts(mid, fps)
-		if math.Abs(pts-start) <= 1.0 {
-			ptsStr := strconv.FormatFloat(pts, 'f', 5, 64)
-			pts, _ = strconv.ParseFloat(ptsStr, 64)
-			return pts
-		} else if pts > start {
-			high = mid - 1
-		} else {
-			low = mid + 1
-		}
+	if fps <= 0 || start < 0 {
+		return -1
 	}
 
-	return -1
+	n := int(math.Round(start * fps))
+	if frames > 0 && n > frames {
+		n = frames
+	}
+	ptsStr := strconv.FormatFloat(calculatePts(n, fps), 'f', 5, 64)
+	pts, _ := strconv.ParseFloat(ptsStr, 64)
+	return pts
 }
 
 func (v Video) GetType() string {
@@ -109,72 +123,52 @@ func (v Video) GetExtension() string {
 	return filepath.Ext(v.FilePath)
 }
 
+func (v Video) GetDuration() int64 {
+	return v.Duration
+}
+
 func (video Video) getFramesAndFps() (float64, int){
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "stream=nb_frames,avg_frame_rate", "-of", "default=noprint_wrappers=1", video.FilePath)
-	output, _ := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-
-	var fps float64 = -1
-	var frames int = 0
-	for _, line := range outputLines {
-		if strings.HasPrefix(line, "avg_frame_rate") && fps == -1 {
-			fpsStr := strings.ReplaceAll(strings.Split(line, "=")[1], "\r", "")
-			fpsParts := strings.Split(fpsStr, "/")
-			if len(fpsParts) == 2 {
-				numerator, _ := strconv.ParseFloat(fpsParts[0], 64)
-				denominator, _ := strconv.ParseFloat(fpsParts[1], 64)
-				if denominator != 0 {
-					fps = numerator / denominator
-				}
-			}
-		}
-		if strings.HasPrefix(line, "nb_frames") && frames == 0 {
-			framesStr := strings.ReplaceAll(strings.Split(line, "=")[1], "\r", "")
-			framesValue, err := strconv.ParseInt(framesStr, 10, 64)
-			if err == nil {
-				frames = int(framesValue)
-			}
+	info := video.Info
+	if info == nil {
+		var err error
+		info, err = Probe(video.FilePath)
+		if err != nil {
+			return -1, 0
 		}
 	}
-	return fps, frames
+
+	stream := info.VideoStream()
+	if stream == nil || stream.FrameRate == 0 {
+		return -1, 0
+	}
+	return stream.FrameRate, int(stream.Frames)
+}
+
+func (video Video) SeekFrame(seconds int64) float64 {
+	return video.SeekFrameAt(time.Duration(seconds) * time.Second)
 }
 
-func (video Video) SeekFrame(time int64) float64 {
+// Same as SeekFrame with sub-second precision. Returns the position in seconds.
+func (video Video) SeekFrameAt(position time.Duration) float64 {
+	seconds := position.Seconds()
 	fps, frames := video.getFramesAndFps()
-	newTime := searchPts(fps, frames, float64(time))
-	if newTime == -1 {return float64(time)}
+	newTime := searchPts(fps, frames, seconds)
+	if newTime == -1 {return seconds}
 	return newTime
 }
 
-func pullVideoStats(videoPath string) (width int, height int, duration int, aspectRatio string) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "stream=width,height,duration,display_aspect_ratio", "-of", "default=noprint_wrappers=1", videoPath)
-	output, _ := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	if len(outputLines) < 5 {
-		return -1, -1, -1, ""
-	}
-
-	for _, v :=range outputLines {
-		switch strings.Split(v, "=")[0] {
-			case "width":
-				width, _ = strconv.Atoi(strings.TrimSuffix(strings.Split(v, "=")[1], "\r"))
-			case "height":
-				height, _ = strconv.Atoi(strings.TrimSuffix(strings.Split(v, "=")[1], "\r"))
-			case "duration":
-				duration, _ = strconv.Atoi(strings.Split(strings.TrimSuffix(strings.Split(v, "=")[1], "\r"), ".")[0])
-				if strings.HasPrefix(v, "duration") {
-					var err error
-					duration, err = strconv.Atoi(strings.Split(strings.TrimSuffix(strings.Split(v, "=")[1], "\r"), ".")[0])
-					if err != nil {
-						duration, _ = strconv.Atoi(strings.Split(strings.TrimSuffix(strings.Split(v, "=")[1], "\r"), ".")[0])
-					}
-				}
-			case "display_aspect_ratio":
-				aspectRatio = strings.TrimSuffix(strings.Split(outputLines[2], "=")[1], "\r")
-		}
+// Length returns the duration of the video without rounding it to whole seconds.
+func (video Video) Length() time.Duration {
+	if video.Info != nil && video.Info.Duration > 0 {
+		return video.Info.Duration
 	}
+	return time.Duration(video.Duration) * time.Second
+}
 
-	return width, height, duration, aspectRatio
+// ParseTimecode parses a timecode (see the package level ParseTimecode) using the frame rate of the video.
+func (video Video) ParseTimecode(value string) (time.Duration, error) {
+	fps, _ := video.getFramesAndFps()
+	return ParseTimecode(value, fps)
 }
 
 /*
@@ -189,71 +183,94 @@ func LoadVideo(videoPath string) (video Video, err error) {
 
 	if file.IsDir() {
 		Logger.Error(fmt.Sprintf(`videoPath: %s is a directory`, videoPath))
-		return Video{}, errors.New("videoPath: %s is a directory")
+		return Video{}, fmt.Errorf("videoPath: %s is a directory", videoPath)
 	}
 
-	width, height, duration, aspectRatio := pullVideoStats(videoPath)
 	fileFormat :=  filepath.Ext(videoPath)
 	if !contains(fileFormat) {
-		Logger.Error(fmt.Sprintf(`videoPath: %s | Video format is not supported\n`, videoPath))
+		Logger.Error(fmt.Sprintf(`videoPath: %s | Video format is not supported`, videoPath))
+		return Video{}, fmt.Errorf("videoPath: %s | video format %s is not supported", videoPath, fileFormat)
+	}
+
+	info, err := Probe(videoPath)
+	if err != nil {
+		Logger.Error(fmt.Sprintf(`videoPath: %s | Unable to probe video | %s`, videoPath, err))
 		return Video{}, err
 	}
-	return Video{
+
+	video = Video{
 		FileName:    filepath.Base(videoPath),
 		FilePath:    videoPath,
 		Format:   fileFormat,
-		Width:       int64(width),
-		Height:      int64(height),
-		Duration:    int64(duration),
-		AspectRatio: aspectRatio,
-		args:        make(Args),
-	}, nil
+		Duration:    int64(info.Duration.Seconds()),
+		Info:        info,
+		keyframes:   &keyframeIndex{},
+	}
+	if stream := info.VideoStream(); stream != nil {
+		video.Width = int64(stream.Width)
+		video.Height = int64(stream.Height)
+		video.AspectRatio = stream.DisplayAspectRatio
+		if video.Duration == 0 {video.Duration = int64(stream.Duration.Seconds())}
+	}
+	return video, nil
+}
+
+// withEffect returns a copy of video with one more effect. video itself is left untouched.
+func (video *Video) withEffect(flag string, arg subArg) *Video {
+	modifiedVideo := *video
+	modifiedVideo.effects = video.effects.push(flag, arg)
+	return &modifiedVideo
 }
 
 func (video *Video) Resize(width int64, height int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key:   "scale",
 			Value: fmt.Sprintf(`scale=%d:%d`, width, height),
 	})
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) ResizeByWidth(width int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "scale",
 			Value: fmt.Sprintf(`scale=%d:%d`, width, -1),
 		})
 		
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) ResizeByHeight(height int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "scale",
 			Value: fmt.Sprintf(`scale=%d:%d`, -1 , height),
 		})
 		
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) Trim(startTime int64, endTime int64) (modifiedVideo *Video){
-	if startTime > endTime {
+	return video.TrimRange(time.Duration(startTime) * time.Second, time.Duration(endTime) * time.Second)
+}
+
+// Same as Trim with sub-second precision, e.g. TrimRange(12480 * time.Millisecond, 20 * time.Second).
+func (video *Video) TrimRange(start time.Duration, end time.Duration) (modifiedVideo *Video){
+	if start > end {
 		Logger.Errorln("Start time cannot be bigger than end time")
 		return &Video{}
 	}
 
-	newStart := video.SeekFrame(startTime)
-	video.args.addArg("-ss", 
+	newStart := video.SeekFrameAt(start)
+	modifiedVideo = video.withEffect("-ss", 
 	subArg{
 		Key: "ss",
-		Value: 	fmt.Sprintf(`%f -to %f`, newStart, float64(endTime)),
+		Value: 	fmt.Sprintf(`%f -to %f`, newStart, end.Seconds()),
 	})
 	
 	
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) Crop(width int64, height int64, startingPositions ...int64) (modifiedVideo *Video) {
@@ -262,7 +279,7 @@ func (video *Video) Crop(width int64, height int64, startingPositions ...int64)
 		if index == 0 {x = value}
 		if index == 1  {y = value}
 	} 
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "crop",
 			Value: fmt.Sprintf(`crop=%d:%d:%d:%d`, width, height, x, y),
@@ -272,40 +289,40 @@ func (video *Video) Crop(width int64, height int64, startingPositions ...int64)
 }
 
 func (video *Video) CropOutTop(pixels int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "crop",
 			Value: fmt.Sprintf(`crop=in_w:in_h-%d:0:out_h`, pixels),
 		})
 		
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) CropOutBottom(pixels int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "crop",
 			Value: fmt.Sprintf(`crop=in_w:in_h-%d:0:0`, pixels),
 		})
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) CropOutLeft(pixels int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "crop",
 			Value: 	fmt.Sprintf(`crop=in_w-%d:in_h:%d:0`, pixels, pixels),
 		})
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) CropOutRight(pixels int64) (modifiedVideo *Video) {
-	video.args.addArg("-filter_complex", 
+	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "crop",
 			Value: fmt.Sprintf(`crop=in_w-%d:in_h:0:0`, pixels),
 		})
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) Blur(intensity int16) (modifiedVideo *Video) {
@@ -313,21 +330,21 @@ func (video *Video) Blur(intensity int16) (modifiedVideo *Video) {
 		Logger.Warn("Blur intensity should be between 0 and 50")
 		return &Video{}
 	}
- 	video.args.addArg("-filter_complex", 
+ 	modifiedVideo = video.withEffect("-filter_complex", 
 		subArg{
 			Key: "boxblur",
 			Value: fmt.Sprintf(`boxblur=%d`, intensity),
 		})
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) NewAspectRatio(aspectRatio float32) (modifiedVideo *Video) {
-	video.args.addArg("-aspect", 
+	modifiedVideo = video.withEffect("-aspect", 
 		subArg{
 			Key: "aspect",
 			Value: fmt.Sprintf(`%f`,aspectRatio),
 		})
-	return video
+	return modifiedVideo
 }
 
 // func (video *Video) NewAspectRatioPadAuto(aspectRatio float32) (modifiedVideo *Video) {
@@ -335,55 +352,179 @@ func (video *Video) NewAspectRatio(aspectRatio float32) (modifiedVideo *Video) {
 // }
 
 func (video *Video) ChangeVolume(multiplier float64) (modifiedVideo *Video) {
-	video.args.addArg("-filter:a", 
+	modifiedVideo = video.withEffect("-filter:a", 
 		subArg{
 			Key: "volume",
 			Value: fmt.Sprintf(`volume=%f`, multiplier),
 		})
-	return  video
+	return modifiedVideo
 }
 
 func (video *Video) MuteAudio() (modifiedVideo *Video) {
-	video.args.addArg("-filter:a", 
+	modifiedVideo = video.withEffect("-filter:a", 
 		subArg{
 			Key: "volume",
 			Value: `volume=0`,
 		})
 
-	return video
+	return modifiedVideo
 }
 
 func (video *Video) Saturate(multiplier float64) (modifiedVideo *Video) {
-	video.args.addArg("-vf", 
+	modifiedVideo = video.withEffect("-vf", 
 		subArg{
 			Key: "saturation",
 			Value: fmt.Sprintf("eq=saturation=%f", multiplier),
 		})
-	return video
+	return modifiedVideo
 }
 
 /*
 	If there exists a file at the specified outputPath, the file will be overwritten.
 */
 func (video Video) Render(outputPath string, videoEncoding string) (outputVideo Video) {
-	removeIfExists(outputPath)
+	outputVideo, err := video.RenderE(outputPath, videoEncoding)
+	if err != nil {
+		return video
+	}
+	return outputVideo
+}
+
+/*
+	Same as Render but returns an error instead of the input video when rendering fails.
+	A failing ffmpeg stage is reported as a *RenderError.
+*/
+func (video Video) RenderE(outputPath string, videoEncoding string) (outputVideo Video, err error) {
+	return video.RenderContext(context.Background(), outputPath, videoEncoding)
+}
 
+/*
+	Same as RenderE but stops the active ffmpeg stage as soon as ctx is cancelled and returns ctx.Err().
+	Options such as WithProgress customize the render.
+*/
+func (video Video) RenderContext(ctx context.Context, outputPath string, videoEncoding string, options ...RenderOption) (outputVideo Video, err error) {
+	settings := newRenderSettings(options)
+	plan, err := video.plan(outputPath, videoEncoding, settings)
+	if err != nil {
+		return video, err
+	}
+
+	err = startRender(ctx, plan, settings)
+	if err != nil {
+		return video, err
+	}
+	return LoadVideo(outputPath)
+}
+
+/*
+	Returns the ffmpeg invocations Render would run for the effects applied so far, without running them.
+	Planning does not consume the applied effects.
+*/
+func (video Video) Plan(outputPath string, videoEncoding string, options ...RenderOption) (RenderPlan, error) {
+	return video.plan(outputPath, videoEncoding, newRenderSettings(options))
+}
+
+func (video Video) plan(outputPath string, videoEncoding string, settings renderSettings) (RenderPlan, error) {
 	if videoEncoding == "" {videoEncoding = VIDEO_ENCODINGS.Best}
 
-	g := GetRenderGraph(VideoGraph)
-	renderStages := g.ProduceOrdering(video.args, &video)
+	encode := DefaultEncodeOptions(videoEncoding)
+	if settings.encode != nil {
+		encode = *settings.encode
+		if encode.Codec == "" {encode.Codec = videoEncoding}
+	}
+	if err := encode.Validate(outputPath); err != nil {
+		Logger.Errorf("outputPath: %s | %s", outputPath, err)
+		return RenderPlan{}, err
+	}
+	settings.encode = &encode
+
+	renderStages := collapseStages(video.effects.list(), &video)
 	
 	if len(renderStages) == 0 {
 		Logger.Errorf("No effects applied. Aborting render.\n")
-		return video
+		return RenderPlan{}, ErrNoEffects
+	}
+
+	if video.hasSubtitleTracks() {
+		extension := outputExtension(outputPath, &video)
+		codec := subtitleCodec(extension)
+		if codec == "" {
+			Logger.Errorf("outputPath: %s | %s files cannot hold subtitle tracks", outputPath, extension)
+			return RenderPlan{}, fmt.Errorf("%s files cannot hold subtitle tracks", extension)
+		}
+		renderStages[0] = append(renderStages[0], "-c:s", codec)
 	}
 
 	// fmt.Printf("\nALL STAGES %+v\n\n", renderStages)
-	startRender(&renderStages, video, outputPath)
-	outputVideo , err := LoadVideo(outputPath)
+	return buildRenderPlan(renderStages[0], video, outputPath, settings), nil
+}
+
+// outputDuration is the duration in seconds the rendered video will have once every trim is applied.
+func (video Video) outputDuration() float64 {
+	duration := video.Length().Seconds()
+	window, ok := composeTrims(video.effects.args()["-ss"])
+	if !ok {
+		return duration
+	}
+	if duration <= 0 || window.end < duration {
+		duration = window.end
+	}
+	return math.Max(duration-window.start, 0)
+}
+
+/*
+	Renders with two-pass encoding at the bitrate that makes the output fit in maxBytes, e.g. the 250 MB limit of Facebook Reels.
+	The bitrate is derived from the duration of the output and the audio bitrate. If the output still ends up bigger than maxBytes,
+	the rendered video is returned along with an error.
+*/
+func (video Video) RenderToFileSize(outputPath string, maxBytes int64, options ...RenderOption) (outputVideo Video, err error) {
+	return video.RenderToFileSizeContext(context.Background(), outputPath, maxBytes, options...)
+}
+
+func (video Video) RenderToFileSizeContext(ctx context.Context, outputPath string, maxBytes int64, options ...RenderOption) (outputVideo Video, err error) {
+	duration := video.outputDuration()
+	if duration <= 0 {
+		return video, errors.New("cannot target a file size without knowing the duration of the output")
+	}
+
+	encode := DefaultEncodeOptions(VIDEO_ENCODINGS.Best)
+	if settings := newRenderSettings(options); settings.encode != nil {
+		encode = *settings.encode
+	}
 
+	audioBitrate := int64(0)
+	if encode.AudioBitrate != "" {
+		audioBitrate, err = parseBitrate(encode.AudioBitrate)
+		if err != nil {
+			return video, err
+		}
+	}
+
+	// leave 3% of the budget for the container overhead
+	totalBitrate := int64(float64(maxBytes) * 8 * 0.97 / duration)
+	videoBitrate := totalBitrate - audioBitrate
+	if videoBitrate < minimumVideoBitrate {
+		return video, fmt.Errorf("%d bytes is too small for %.2f seconds of video", maxBytes, duration)
+	}
+
+	encode.CRF = 0
+	encode.Bitrate = strconv.FormatInt(videoBitrate, 10)
+	encode.MaxRate = ""
+	encode.BufSize = ""
+	encode.TwoPass = true
+	Logger.Infof("outputPath: %s | Targeting %d bytes with a video bitrate of %d bps", outputPath, maxBytes, videoBitrate)
+
+	outputVideo, err = video.RenderContext(ctx, outputPath, encode.Codec, append(options, WithEncodeOptions(encode))...)
 	if err != nil {
-		return video
+		return video, err
 	}
-	return outputVideo
-}
\ No newline at end of file
+
+	file, err := os.Stat(outputPath)
+	if err != nil {
+		return outputVideo, err
+	}
+	if file.Size() > maxBytes {
+		return outputVideo, fmt.Errorf("rendered file is %d bytes, over the %d bytes limit", file.Size(), maxBytes)
+	}
+	return outputVideo, nil
+}